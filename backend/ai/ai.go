@@ -14,6 +14,11 @@ type AIDifficulty string
 
 var validDifficulties = []AIDifficulty{"easy", "medium", "hard"}
 
+// Returns the difficulties an AI can be created with.
+func Difficulties() []AIDifficulty {
+	return slices.Clone(validDifficulties)
+}
+
 type AI interface {
 	GetBestMove(ctx context.Context, aiPlayer games.PlayerSide) (json.RawMessage, error)
 	SetGame(game games.Game)
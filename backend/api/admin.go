@@ -0,0 +1,212 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/CDavidSV/online-flip-flop/ws"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Protects the admin API with the bearer token from the config.
+// Every request is rejected while no token is configured. Rejected requests are logged with their remote address.
+func AdminAuth(cfg *config.Store, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			token := cfg.Load().AdminToken
+			if token == "" {
+				logRejectedAdminRequest(logger, req, apperrors.ErrAdminDisabled)
+				writeError(res, apperrors.ErrAdminDisabled)
+				return
+			}
+
+			provided, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				logRejectedAdminRequest(logger, req, apperrors.ErrInvalidAdminToken)
+				writeError(res, apperrors.ErrInvalidAdminToken)
+				return
+			}
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// Returns the effective config with secrets redacted.
+func ConfigHandler(cfg *config.Store, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logAdminAction(logger, req, "read_config")
+		writeJSON(res, http.StatusOK, cfg.Load().Values())
+	}
+}
+
+// Reloads the config and returns the settings that were applied and rejected.
+func ReloadConfigHandler(cfg *config.Store, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		result, err := cfg.Reload()
+		if err != nil {
+			logAdminAction(logger, req, "reload_config", "error", err)
+			writeError(res, apperrors.ErrConfigReloadFailed, err.Error())
+			return
+		}
+
+		logAdminAction(logger, req, "reload_config", "applied", result.Applied, "rejected", result.Rejected)
+		writeJSON(res, http.StatusOK, result)
+	}
+}
+
+type closeRoomRequest struct {
+	Reason string `json:"reason" validate:"max=200"`
+}
+
+type kickClientRequest struct {
+	Reason string `json:"reason" validate:"max=200"`
+}
+
+type announcementRequest struct {
+	Message string `json:"message" validate:"required,max=500"`
+}
+
+// Writes an admin action to the log.
+func logAdminAction(logger *slog.Logger, req *http.Request, action string, attrs ...any) {
+	attrs = append([]any{"action", action, "request_id", middleware.GetReqID(req.Context()), "remote_addr", req.RemoteAddr}, attrs...)
+	logger.Info("Admin action", attrs...)
+}
+
+// Writes a request rejected by the admin auth to the log.
+func logRejectedAdminRequest(logger *slog.Logger, req *http.Request, err error) {
+	logger.Warn("Rejected admin request",
+		"error", err,
+		"method", req.Method,
+		"path", req.URL.Path,
+		"request_id", middleware.GetReqID(req.Context()),
+		"remote_addr", req.RemoteAddr,
+	)
+}
+
+// Lists every room of the cluster, including closed rooms that have not been removed yet.
+func AdminRoomsHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logAdminAction(logger, req, "list_rooms")
+		writeJSON(res, http.StatusOK, server.ListRoomDetails(req.Context()))
+	}
+}
+
+// Returns the full state of a room, including the chat history.
+// Rooms of other nodes are looked up on the node that owns them.
+func AdminRoomHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		roomID := chi.URLParam(req, "roomID")
+		logAdminAction(logger, req, "read_room", "room_id", roomID)
+
+		details, err := server.FindRoomDetails(req.Context(), roomID)
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, details)
+	}
+}
+
+// Exports the event log of a room, with the client IDs and the chat of players and spectators.
+func AdminRoomLogHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		roomID := chi.URLParam(req, "roomID")
+		logAdminAction(logger, req, "read_room_log", "room_id", roomID)
+
+		events, err := server.FindRoomEvents(req.Context(), roomID)
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, events)
+	}
+}
+
+// Rebuilds a room from its event log and returns the state the log leads to,
+// to check a log against what the clients saw.
+func AdminReplayRoomHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		roomID := chi.URLParam(req, "roomID")
+		logAdminAction(logger, req, "replay_room", "room_id", roomID)
+
+		details, err := server.ReplayRoomEvents(req.Context(), roomID)
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, details)
+	}
+}
+
+// Force-closes a room, kicking out every client with the given reason.
+// Rooms of other nodes are closed by the node that owns them.
+func CloseRoomHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		roomID := chi.URLParam(req, "roomID")
+		var body closeRoomRequest
+		if err := readJSON(res, req, &body); err != nil {
+			logAdminAction(logger, req, "close_room", "room_id", roomID, "error", err)
+			return
+		}
+		if body.Reason == "" {
+			body.Reason = "closed_by_admin"
+		}
+
+		if err := server.CloseRoom(req.Context(), roomID, body.Reason); err != nil {
+			logAdminAction(logger, req, "close_room", "room_id", roomID, "reason", body.Reason, "error", err)
+			writeError(res, err)
+			return
+		}
+
+		logAdminAction(logger, req, "close_room", "room_id", roomID, "reason", body.Reason)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Removes a client from their room and closes their connection, on whichever node they are connected to.
+func KickClientHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		clientID := chi.URLParam(req, "clientID")
+		var body kickClientRequest
+		if err := readJSON(res, req, &body); err != nil {
+			logAdminAction(logger, req, "kick_client", "client_id", clientID, "error", err)
+			return
+		}
+		if body.Reason == "" {
+			body.Reason = "kicked_by_admin"
+		}
+
+		if err := server.KickClient(req.Context(), clientID, body.Reason); err != nil {
+			logAdminAction(logger, req, "kick_client", "client_id", clientID, "reason", body.Reason, "error", err)
+			writeError(res, err)
+			return
+		}
+
+		logAdminAction(logger, req, "kick_client", "client_id", clientID, "reason", body.Reason)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Sends an announcement to every client connected to the cluster.
+func AnnouncementHandler(server *ws.Server, logger *slog.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var body announcementRequest
+		if err := readJSON(res, req, &body); err != nil {
+			logAdminAction(logger, req, "announcement", "error", err)
+			return
+		}
+
+		sent := server.Announce(req.Context(), body.Message)
+		logAdminAction(logger, req, "announcement", "message", body.Message, "recipients", sent)
+		writeJSON(res, http.StatusOK, map[string]int{"recipients": sent})
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/CDavidSV/online-flip-flop/internal/validator"
+)
+
+// Writes the data as a JSON response with the given status code.
+func writeJSON(res http.ResponseWriter, status int, data any) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(data)
+}
+
+var validate = validator.New()
+
+// Decodes and validates a JSON request body into v, an empty body leaves v unchanged.
+// Writes an error response and returns why the body was rejected if it is not valid.
+func readJSON(res http.ResponseWriter, req *http.Request, v any) error {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeError(res, apperrors.ErrInvalidMessageFormat)
+		return fmt.Errorf("%w: %v", apperrors.ErrInvalidMessageFormat, err)
+	}
+
+	if ok, errors := validate.Validate(v); !ok {
+		writeError(res, apperrors.ErrValidationFailed, errors)
+		return fmt.Errorf("%w: %v", apperrors.ErrValidationFailed, errors)
+	}
+	return nil
+}
+
+// Builds and writes an error response from an app error.
+func writeError(res http.ResponseWriter, err error, details ...any) {
+	writeJSON(res, statusCode(err), apperrors.New(err, details...))
+}
+
+// Maps an app error to the HTTP status code returned to clients.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrRoomNotFound),
+		errors.Is(err, apperrors.ErrClientNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperrors.ErrRoomClosed):
+		return http.StatusConflict
+	case errors.Is(err, apperrors.ErrGameNotEnded),
+		errors.Is(err, apperrors.ErrGameNotStarted):
+		return http.StatusConflict
+	case errors.Is(err, apperrors.ErrValidationFailed),
+		errors.Is(err, apperrors.ErrInvalidMessageFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, apperrors.ErrInvalidAdminToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, apperrors.ErrAdminDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, apperrors.ErrConfigReloadFailed):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, apperrors.ErrNodeTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/CDavidSV/online-flip-flop/ws"
+)
+
+type runtimeStats struct {
+	UptimeSeconds  int64     `json:"uptime_seconds"`
+	Goroutines     int       `json:"goroutines"`
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	SysBytes       uint64    `json:"sys_bytes"`
+	NumGC          uint32    `json:"num_gc"`
+	Rooms          int       `json:"rooms"`
+	Connections    int64     `json:"connections"`
+	LastCleanup    time.Time `json:"last_cleanup"`
+}
+
+type healthStatus struct {
+	Status string              `json:"status"`
+	Error  *apperrors.AppError `json:"error,omitempty"`
+	Stats  runtimeStats        `json:"stats"`
+}
+
+// Collects runtime and server stats included in the health responses.
+func collectStats(server *ws.Server) runtimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return runtimeStats{
+		UptimeSeconds:  int64(server.Uptime().Seconds()),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+		Rooms:          server.RoomCount(),
+		Connections:    server.ConnectionCount(),
+		LastCleanup:    server.LastCleanup(),
+	}
+}
+
+// Liveness probe, succeeds as long as the process is able to serve requests.
+func HealthHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, http.StatusOK, healthStatus{
+			Status: "ok",
+			Stats:  collectStats(server),
+		})
+	}
+}
+
+// Readiness probe, fails while the server is draining, above its room capacity or when the cleanup job is stuck.
+func ReadyHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if err := server.Ready(); err != nil {
+			writeJSON(res, http.StatusServiceUnavailable, healthStatus{
+				Status: "unavailable",
+				Error:  apperrors.New(err),
+				Stats:  collectStats(server),
+			})
+			return
+		}
+
+		writeJSON(res, http.StatusOK, healthStatus{
+			Status: "ok",
+			Stats:  collectStats(server),
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+// Interval between comments sent on an idle event stream, so proxies do not close it.
+const eventStreamKeepAlive = 15 * time.Second
+
+// Lists all open rooms of the cluster. Rooms can be filtered with the status, game_mode and game_type query parameters.
+func ListRoomsHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		status := ws.Status(query.Get("status"))
+		gameMode := ws.GameMode(query.Get("game_mode"))
+		gameType := games.GameType(query.Get("game_type"))
+
+		rooms := make([]ws.RoomSummary, 0)
+		for _, room := range server.ListRooms(req.Context()) {
+			if (status != "" && room.Status != status) ||
+				(gameMode != "" && room.GameMode != gameMode) ||
+				(gameType != "" && room.GameType != gameType) {
+				continue
+			}
+			rooms = append(rooms, room)
+		}
+
+		writeJSON(res, http.StatusOK, rooms)
+	}
+}
+
+// Returns the details and game state of a room, as seen by a spectator.
+// Rooms of other nodes are looked up on the node that owns them.
+func RoomHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		room, err := server.FindRoom(req.Context(), chi.URLParam(req, "roomID"))
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, room)
+	}
+}
+
+// Exports the move history of the last finished game in a room.
+func GameHistoryHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		record, err := server.FindGameRecord(req.Context(), chi.URLParam(req, "roomID"))
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, record)
+	}
+}
+
+// Writes a feed event in the server-sent events format.
+func writeEvent(res http.ResponseWriter, event ws.FeedEvent) error {
+	_, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+// Streams the spectator feed of a room as server-sent events. Clients resuming with the Last-Event-ID header
+// receive the events they missed, or the current game state if those events are no longer buffered.
+// Feeds of rooms of other nodes are relayed from the node that owns them.
+func RoomEventsHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		// An invalid ID starts a new stream, as if the client was not resuming
+		var lastEventID *uint64
+		if id, err := strconv.ParseUint(req.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			lastEventID = &id
+		}
+
+		sub, unsubscribe, err := server.SubscribeRoomFeed(req.Context(), chi.URLParam(req, "roomID"), lastEventID)
+		if err != nil {
+			writeError(res, err)
+			return
+		}
+		defer unsubscribe()
+
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("X-Accel-Buffering", "no") // Disables response buffering in nginx
+		res.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(res)
+		for _, event := range sub.Backlog {
+			if writeEvent(res, event) != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventStreamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case event, ok := <-sub.Events:
+				// The room closed or the client fell behind, clients reconnect with their last event ID
+				if !ok || writeEvent(res, event) != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			}
+
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/ws"
+)
+
+type serverInfo struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	NodeID        string `json:"node_id"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Rooms         int    `json:"rooms"`
+	MinProtocol   int    `json:"min_protocol"`
+	MaxProtocol   int    `json:"max_protocol"`
+}
+
+// Returns general information about the server.
+func ServerInfoHandler(server *ws.Server) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, http.StatusOK, serverInfo{
+			Name:          "online-flip-flop",
+			Version:       config.Version,
+			NodeID:        server.NodeID(),
+			UptimeSeconds: int64(server.Uptime().Seconds()),
+			Rooms:         server.RoomCount(),
+			MinProtocol:   ws.ProtocolVersionMin,
+			MaxProtocol:   ws.ProtocolVersionCurrent,
+		})
+	}
+}
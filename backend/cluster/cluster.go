@@ -0,0 +1,54 @@
+// Package cluster lets several game server instances share the rooms they hold.
+//
+// Every room is owned by a single node, recorded in the Directory. Clients can connect to any node,
+// which forwards their messages to the owner of their room over the Bus and relays the replies.
+package cluster
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	ClaimTTL     = 3 * time.Minute // Time a room claim or node membership lasts unless it is renewed
+	BusQueueSize = 4096            // Messages waiting to be published before Publish fails
+)
+
+// Maps room IDs to the node that owns them, and keeps track of the nodes of the cluster.
+type Directory interface {
+	// Claims a room for a node, or renews the claim if the node already owns it.
+	// Fails with ErrRoomTaken if another node owns the room.
+	Claim(ctx context.Context, roomID, nodeID string) error
+
+	// Returns the node that owns a room, or ErrRoomNotFound if nobody does.
+	Owner(ctx context.Context, roomID string) (string, error)
+
+	// Gives up a claim. Claims of other nodes are left alone.
+	Release(ctx context.Context, roomID, nodeID string) error
+
+	// Adds a node to the cluster, or renews its membership.
+	Join(ctx context.Context, nodeID string) error
+
+	// Removes a node from the cluster.
+	Leave(ctx context.Context, nodeID string) error
+
+	// Returns the nodes of the cluster whose membership has not expired.
+	Nodes(ctx context.Context) ([]string, error)
+}
+
+// Pub/sub channel between nodes. Messages published to a topic reach every subscriber in order.
+type Bus interface {
+	// Queues a message to be published without waiting for it to be delivered.
+	// Fails with ErrBusFull if too many messages are waiting.
+	Publish(topic string, msg []byte) error
+
+	// Calls handler with every message published to the topic until the subscription is closed.
+	// Messages are handled one at a time, in the order they were published.
+	Subscribe(ctx context.Context, topic string, handler func(msg []byte)) (io.Closer, error)
+}
+
+// Returns the topic a node receives its messages on.
+func NodeTopic(nodeID string) string {
+	return "flipflop:node:" + nodeID
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+// Directory kept in process memory. Used by a server running on its own,
+// and by several servers in the same process to simulate a cluster.
+type MemoryDirectory struct {
+	mu     sync.Mutex
+	claims map[string]memoryClaim
+	nodes  map[string]time.Time // Expiry of the membership of each node
+}
+
+type memoryClaim struct {
+	nodeID    string
+	expiresAt time.Time
+}
+
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{claims: make(map[string]memoryClaim), nodes: make(map[string]time.Time)}
+}
+
+func (d *MemoryDirectory) Claim(ctx context.Context, roomID, nodeID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if claim, ok := d.claims[roomID]; ok && claim.nodeID != nodeID && time.Now().Before(claim.expiresAt) {
+		return apperrors.ErrRoomTaken
+	}
+	d.claims[roomID] = memoryClaim{nodeID: nodeID, expiresAt: time.Now().Add(ClaimTTL)}
+	return nil
+}
+
+func (d *MemoryDirectory) Owner(ctx context.Context, roomID string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	claim, ok := d.claims[roomID]
+	if !ok || !time.Now().Before(claim.expiresAt) {
+		return "", apperrors.ErrRoomNotFound
+	}
+	return claim.nodeID, nil
+}
+
+func (d *MemoryDirectory) Release(ctx context.Context, roomID, nodeID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if claim, ok := d.claims[roomID]; ok && claim.nodeID == nodeID {
+		delete(d.claims, roomID)
+	}
+	return nil
+}
+
+func (d *MemoryDirectory) Join(ctx context.Context, nodeID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nodes[nodeID] = time.Now().Add(ClaimTTL)
+	return nil
+}
+
+func (d *MemoryDirectory) Leave(ctx context.Context, nodeID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.nodes, nodeID)
+	return nil
+}
+
+func (d *MemoryDirectory) Nodes(ctx context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nodes := make([]string, 0, len(d.nodes))
+	for nodeID, expiresAt := range d.nodes {
+		if time.Now().Before(expiresAt) {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes, nil
+}
+
+// Bus that delivers messages within the process, for a server running on its own
+// or several servers in the same process.
+type MemoryBus struct {
+	mu            sync.Mutex
+	subscriptions map[string][]*memorySubscription
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subscriptions: make(map[string][]*memorySubscription)}
+}
+
+// Queues the message for every subscriber of the topic. A full subscriber does not keep the message
+// from the others, the error is returned once all of them were tried.
+func (b *MemoryBus) Publish(topic string, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for _, sub := range b.subscriptions[topic] {
+		if pushErr := sub.push(slices.Clone(msg)); pushErr != nil && err == nil {
+			err = pushErr
+		}
+	}
+	return err
+}
+
+func (b *MemoryBus) Subscribe(ctx context.Context, topic string, handler func(msg []byte)) (io.Closer, error) {
+	sub := &memorySubscription{
+		bus:     b,
+		topic:   topic,
+		handler: handler,
+		ready:   make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	b.subscriptions[topic] = append(b.subscriptions[topic], sub)
+	b.mu.Unlock()
+
+	go sub.run()
+	return sub, nil
+}
+
+// Queue of the messages waiting to be handled by a subscriber.
+// Messages are handled on their own goroutine, so publishers never wait on subscribers.
+type memorySubscription struct {
+	bus      *MemoryBus
+	topic    string
+	handler  func(msg []byte)
+	mu       sync.Mutex
+	messages [][]byte
+	closed   bool
+	ready    chan struct{} // Wakes up the handler
+}
+
+func (s *memorySubscription) push(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	if len(s.messages) >= BusQueueSize {
+		return apperrors.ErrBusFull
+	}
+	s.messages = append(s.messages, msg)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *memorySubscription) run() {
+	for range s.ready {
+		s.mu.Lock()
+		messages, closed := s.messages, s.closed
+		s.messages = nil
+		s.mu.Unlock()
+
+		if closed {
+			return
+		}
+		for _, msg := range messages {
+			s.handler(msg)
+		}
+	}
+}
+
+func (s *memorySubscription) Close() error {
+	s.bus.mu.Lock()
+	s.bus.subscriptions[s.topic] = slices.DeleteFunc(s.bus.subscriptions[s.topic], func(sub *memorySubscription) bool {
+		return sub == s
+	})
+	s.bus.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ready)
+	}
+	return nil
+}
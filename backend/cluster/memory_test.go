@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+func TestMemoryBusDeliversPastFullSubscriber(t *testing.T) {
+	bus := NewMemoryBus()
+	ctx := context.Background()
+
+	// The first subscriber is stuck handling the first message, so its queue fills up
+	release := make(chan struct{})
+	defer close(release)
+	stuck, _ := bus.Subscribe(ctx, "topic", func(msg []byte) { <-release })
+	defer stuck.Close()
+
+	received := make(chan string, BusQueueSize)
+	other, _ := bus.Subscribe(ctx, "topic", func(msg []byte) { received <- string(msg) })
+	defer other.Close()
+
+	bus.Publish("topic", []byte("first"))
+	<-received
+
+	// Waits until the stuck subscriber took the first message off its queue
+	sub := stuck.(*memorySubscription)
+	deadline := time.Now().Add(time.Second)
+	for {
+		sub.mu.Lock()
+		queued := len(sub.messages)
+		sub.mu.Unlock()
+		if queued == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for range BusQueueSize {
+		if err := bus.Publish("topic", []byte("filler")); err != nil {
+			t.Fatalf("publishing before the queue is full: %v", err)
+		}
+	}
+
+	for range BusQueueSize {
+		<-received
+	}
+
+	if err := bus.Publish("topic", []byte("last")); !errors.Is(err, apperrors.ErrBusFull) {
+		t.Fatalf("got error %v, want %v", err, apperrors.ErrBusFull)
+	}
+
+	select {
+	case msg := <-received:
+		if msg != "last" {
+			t.Fatalf("got message %q, want %q", msg, "last")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the other subscriber did not receive the message")
+	}
+}
+
+func TestMemoryDirectoryNodes(t *testing.T) {
+	directory := NewMemoryDirectory()
+	ctx := context.Background()
+
+	directory.Join(ctx, "node-b")
+	directory.Join(ctx, "node-a")
+	directory.Join(ctx, "node-c")
+	directory.Join(ctx, "node-a")
+	directory.Leave(ctx, "node-c")
+
+	nodes, err := directory.Nodes(ctx)
+	if err != nil {
+		t.Fatalf("listing nodes: %v", err)
+	}
+	if want := []string{"node-a", "node-b"}; !slices.Equal(nodes, want) {
+		t.Fatalf("got nodes %v, want %v", nodes, want)
+	}
+}
@@ -0,0 +1,178 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	roomKeyPrefix  = "flipflop:room:"
+	nodesKey       = "flipflop:nodes" // Sorted set of the nodes, scored by the expiry of their membership in Unix milliseconds
+	publishTimeout = 5 * time.Second  // Time a single publish can take before it is given up
+)
+
+// Claims a room if it is free or already owned by the node, extending the claim.
+var claimScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// Deletes a claim only if it belongs to the node.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Opens a client to the Redis compatible server at the given URL, like redis://localhost:6379/0.
+func NewRedisClient(url string) (*redis.Client, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(options), nil
+}
+
+// Directory stored in Redis, shared by every node of the cluster.
+// Claims and memberships expire after ClaimTTL so the rooms of a node that died can be found again.
+type RedisDirectory struct {
+	client *redis.Client
+}
+
+func NewRedisDirectory(client *redis.Client) *RedisDirectory {
+	return &RedisDirectory{client: client}
+}
+
+func (d *RedisDirectory) Claim(ctx context.Context, roomID, nodeID string) error {
+	claimed, err := claimScript.Run(ctx, d.client, []string{roomKeyPrefix + roomID}, nodeID, ClaimTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if claimed == 0 {
+		return apperrors.ErrRoomTaken
+	}
+	return nil
+}
+
+func (d *RedisDirectory) Owner(ctx context.Context, roomID string) (string, error) {
+	owner, err := d.client.Get(ctx, roomKeyPrefix+roomID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", apperrors.ErrRoomNotFound
+	}
+	return owner, err
+}
+
+func (d *RedisDirectory) Release(ctx context.Context, roomID, nodeID string) error {
+	return releaseScript.Run(ctx, d.client, []string{roomKeyPrefix + roomID}, nodeID).Err()
+}
+
+// Renews the membership of the node and removes the nodes whose membership expired.
+func (d *RedisDirectory) Join(ctx context.Context, nodeID string) error {
+	now := time.Now()
+	_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, nodesKey, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		pipe.ZAdd(ctx, nodesKey, redis.Z{Score: float64(now.Add(ClaimTTL).UnixMilli()), Member: nodeID})
+		return nil
+	})
+	return err
+}
+
+func (d *RedisDirectory) Leave(ctx context.Context, nodeID string) error {
+	return d.client.ZRem(ctx, nodesKey, nodeID).Err()
+}
+
+func (d *RedisDirectory) Nodes(ctx context.Context) ([]string, error) {
+	return d.client.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+type redisMessage struct {
+	topic string
+	msg   []byte
+}
+
+// Bus on top of Redis pub/sub. Messages are published in order by a single goroutine,
+// so callers never wait on the network.
+type RedisBus struct {
+	client *redis.Client
+	outbox chan redisMessage
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Returns a bus that publishes through the client until it is closed.
+func NewRedisBus(client *redis.Client, logger *slog.Logger) *RedisBus {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &RedisBus{
+		client: client,
+		outbox: make(chan redisMessage, BusQueueSize),
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go b.run()
+	return b
+}
+
+func (b *RedisBus) Publish(topic string, msg []byte) error {
+	select {
+	case b.outbox <- redisMessage{topic: topic, msg: msg}:
+		return nil
+	default:
+		return apperrors.ErrBusFull
+	}
+}
+
+// Publishes queued messages until the bus is closed.
+func (b *RedisBus) run() {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case m := <-b.outbox:
+			publishCtx, cancel := context.WithTimeout(b.ctx, publishTimeout)
+			if err := b.client.Publish(publishCtx, m.topic, m.msg).Err(); err != nil {
+				b.logger.Error("Failed to publish cluster message", "topic", m.topic, "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (b *RedisBus) Subscribe(ctx context.Context, topic string, handler func(msg []byte)) (io.Closer, error) {
+	pubsub := b.client.Subscribe(ctx, topic)
+
+	// Waits for the subscription to be confirmed, so no message published afterwards is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return pubsub, nil
+}
+
+// Stops publishing. Messages still queued are discarded.
+func (b *RedisBus) Close() error {
+	b.cancel()
+	return nil
+}
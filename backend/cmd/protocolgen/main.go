@@ -0,0 +1,355 @@
+// Command protocolgen writes the JSON Schema of the websocket protocol, generated from the message
+// catalogue in the ws package. Clients generate their message types from the schema.
+//
+// With -check it fails instead if the schema file is out of date, so CI can catch a stale schema.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/ws"
+)
+
+type schema = map[string]any
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+// Builds the schema of the protocol, with a definition for every message and named type.
+type generator struct {
+	defs  schema
+	types map[string]reflect.Type
+	enums map[reflect.Type][]any
+}
+
+func main() {
+	output := flag.String("o", "protocol.schema.json", "Path of the schema file")
+	check := flag.Bool("check", false, "Fail if the schema file is out of date instead of writing it")
+	flag.Parse()
+
+	data, err := generate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *check {
+		current, err := os.ReadFile(*output)
+		if err != nil || !bytes.Equal(current, data) {
+			fmt.Fprintf(os.Stderr, "%s is out of date, run go generate ./ws\n", *output)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Returns the encoded schema of the protocol.
+func generate() ([]byte, error) {
+	g := &generator{
+		defs:  schema{},
+		types: map[string]reflect.Type{},
+		enums: map[reflect.Type][]any{},
+	}
+
+	for _, values := range ws.ProtocolEnums {
+		v := reflect.ValueOf(values)
+		for i := range v.Len() {
+			g.enums[v.Type().Elem()] = append(g.enums[v.Type().Elem()], v.Index(i).Interface())
+		}
+	}
+
+	messages := map[ws.Direction][]any{}
+	for _, spec := range ws.ProtocolMessages {
+		name, def, err := g.message(spec)
+		if err != nil {
+			return nil, err
+		}
+		g.defs[name] = def
+		messages[spec.Direction] = append(messages[spec.Direction], ref(name))
+	}
+	g.defs["IncomingMessage"] = schema{
+		"description": "Message sent by clients to the server",
+		"oneOf":       messages[ws.DirectionIncoming],
+	}
+	g.defs["OutgoingMessage"] = schema{
+		"description": "Message sent by the server to clients",
+		"oneOf":       messages[ws.DirectionOutgoing],
+	}
+
+	doc := schema{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Online Flip Flop websocket protocol",
+		"description": fmt.Sprintf("Messages of protocol versions %d to %d. Generated by cmd/protocolgen, do not edit.", ws.ProtocolVersionMin, ws.ProtocolVersionCurrent),
+		"oneOf":       []any{ref("IncomingMessage"), ref("OutgoingMessage")},
+		"$defs":       g.defs,
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Returns the name and the schema of a message envelope.
+func (g *generator) message(spec ws.MessageSpec) (string, schema, error) {
+	properties := schema{
+		"type": schema{"const": spec.Type},
+	}
+	required := []string{"type"}
+
+	if spec.Direction == ws.DirectionIncoming {
+		properties["request_id"] = schema{"type": "string", "format": "uuid"}
+		properties["room_id"] = schema{
+			"type":        "string",
+			"description": "Room the message is about. Only needed while the client is in several rooms, from protocol version 3",
+		}
+		required = append(required, "request_id")
+	} else {
+		properties["room_id"] = schema{
+			"type":        "string",
+			"description": "Room the message is about. Sent from protocol version 3",
+		}
+		properties["request_id"] = schema{"type": "string", "description": "ID of the request the message answers"}
+		properties["seq"] = schema{
+			"type":        "integer",
+			"minimum":     0,
+			"description": "Sequence number of the room event the message is, or refers to. Sent from protocol version 2",
+		}
+	}
+
+	if spec.Payload != nil {
+		payload, err := g.schemaOf(reflect.TypeOf(spec.Payload))
+		if err != nil {
+			return "", nil, fmt.Errorf("payload of %s message %q: %w", spec.Direction, spec.Type, err)
+		}
+		properties["payload"] = payload
+		required = append(required, "payload")
+	}
+
+	name := pascalCase(string(spec.Direction)) + pascalCase(string(spec.Type)) + "Message"
+	return name, schema{
+		"description":        spec.Description,
+		"type":               "object",
+		"properties":         properties,
+		"required":           required,
+		"x-protocol-version": ws.MsgTypeVersion(spec.Type),
+	}, nil
+}
+
+// Returns the schema of a type. Named types are added to the definitions and referenced.
+func (g *generator) schemaOf(t reflect.Type) (schema, error) {
+	switch t {
+	case timeType:
+		return schema{"type": "string", "format": "date-time"}, nil
+	case rawType:
+		return schema{}, nil
+	}
+
+	if t.Kind() == reflect.Pointer {
+		return g.schemaOf(t.Elem())
+	}
+
+	if t.Name() == "" || t.PkgPath() == "" {
+		return g.inline(t)
+	}
+
+	if existing, ok := g.types[t.Name()]; ok {
+		if existing != t {
+			return nil, fmt.Errorf("types %s and %s have the same name", existing, t)
+		}
+		return ref(t.Name()), nil
+	}
+
+	// Registered before it is built so that recursive types end up as references
+	g.types[t.Name()] = t
+	def, err := g.inline(t)
+	if err != nil {
+		return nil, err
+	}
+	if values, ok := g.enums[t]; ok {
+		def["enum"] = values
+	}
+	g.defs[t.Name()] = def
+	return ref(t.Name()), nil
+}
+
+// Returns the schema of a type without referencing its definition.
+func (g *generator) inline(t reflect.Type) (schema, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}, nil
+	case reflect.Bool:
+		return schema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}, nil
+	case reflect.Interface:
+		return schema{}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return schema{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map %s does not have string keys", t)
+		}
+		values, err := g.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return schema{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return g.object(t)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// Returns the schema of a struct from its json and validate tags.
+func (g *generator) object(t reflect.Type) (schema, error) {
+	properties := schema{}
+	required := []string{}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		if field.Anonymous {
+			return nil, fmt.Errorf("embedded field %s in %s is not supported", field.Name, t)
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := g.schemaOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s of %s: %w", field.Name, t, err)
+		}
+		rules, hasRules := field.Tag.Lookup("validate")
+		_, hasEnum := g.enums[field.Type]
+		property = withRules(property, field.Type, rules, hasEnum)
+		properties[name] = property
+
+		// Fields are required unless they are omitted when empty, or validated as optional
+		omitEmpty := strings.Contains(options, "omitempty")
+		if hasRequired(rules) || (!omitEmpty && !hasRules) {
+			required = append(required, name)
+		}
+	}
+
+	return schema{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, nil
+}
+
+// Adds the constraints of validate rules that a schema can express.
+// Allowed values are left out for types whose definition already lists them.
+func withRules(s schema, t reflect.Type, rules string, hasEnum bool) schema {
+	if rules == "" {
+		return s
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	isString := t.Kind() == reflect.String
+
+	// Referenced definitions are shared, so the constraints go on a copy that refers to them
+	constrained := schema{}
+	for key, value := range s {
+		constrained[key] = value
+	}
+
+	for rule := range strings.SplitSeq(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "uuid4":
+			constrained["format"] = "uuid"
+		case "min", "gte":
+			if isString {
+				constrained["minLength"] = number(param)
+			} else {
+				constrained["minimum"] = number(param)
+			}
+		case "max", "lte":
+			if isString {
+				constrained["maxLength"] = number(param)
+			} else {
+				constrained["maximum"] = number(param)
+			}
+		case "oneof":
+			if hasEnum {
+				continue
+			}
+			values := []any{}
+			for value := range strings.FieldsSeq(param) {
+				if isString {
+					values = append(values, value)
+				} else {
+					values = append(values, number(value))
+				}
+			}
+			constrained["enum"] = values
+		}
+	}
+	return constrained
+}
+
+// Checks if validate rules make a field required.
+func hasRequired(rules string) bool {
+	for rule := range strings.SplitSeq(rules, ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+func number(value string) any {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return value
+	}
+	return n
+}
+
+func ref(name string) schema {
+	return schema{"$ref": "#/$defs/" + name}
+}
+
+// Converts a snake_case name to PascalCase.
+func pascalCase(name string) string {
+	var builder strings.Builder
+	for part := range strings.SplitSeq(name, "_") {
+		if part != "" {
+			builder.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return builder.String()
+}
@@ -1,10 +1,32 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-chi/cors"
-	"github.com/labstack/gommon/log"
+	"github.com/CDavidSV/online-flip-flop/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Banner = `    _________             ________
+   / ____/ (_)___        / ____/ /___  ____
+  / /_  / / / __ \______/ /_  / / __ \/ __ \
+ / __/ / / / /_/ /_____/ __/ / / /_/ / /_/ /
+/_/   /_/_/ .___/     /_/   /_/\____/ .___/
+         /_/                       /_/      `
+
+	Version = "1.1.0"
+
+	EnvPrefix = "FLIPFLOP_" // Prefix of the environment variables read by Load
 )
 
 var allowedDevOrigins = []string{
@@ -15,43 +37,265 @@ var allowedProdOrigins = []string{
 	"https://flipflop.cdavidsv.dev",
 }
 
-func init() {
-	host := flag.String("host", "localhost:8000", "Host address for the server")
-	prod := flag.Bool("prod", false, "Run in production mode")
-	flag.Parse()
+// Server configuration.
+// Every field can be set from the config file using its yaml key, from an environment variable
+// named after the key with the FLIPFLOP_ prefix, or from a flag named after the key with dashes.
+// Lower bounds of durations are durations themselves, a bare number would be read as nanoseconds.
+type Config struct {
+	Host           string   `yaml:"host" json:"host" validate:"required"`
+	Prod           bool     `yaml:"prod" json:"prod"`                       // Uses the production origins when no origins are set
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"` // Origins allowed to open websocket connections
+	LogLevel       string   `yaml:"log_level" json:"log_level" validate:"oneof=debug info warn error"`
+	LogFormat      string   `yaml:"log_format" json:"log_format" validate:"oneof=text json"`
+
+	AIMoveDelay         time.Duration `yaml:"ai_move_delay" json:"ai_move_delay" validate:"min=0"`                  // Delay before the AI makes a move
+	AIThinkTimeout      time.Duration `yaml:"ai_think_timeout" json:"ai_think_timeout" validate:"min=1s"`           // Time the AI has to think before timing out
+	RoomInactiveTimeout time.Duration `yaml:"room_inactive_timeout" json:"room_inactive_timeout" validate:"min=1m"` // Time before an inactive room is closed
+	AbandonGracePeriod  time.Duration `yaml:"abandon_grace_period" json:"abandon_grace_period" validate:"min=5s"`   // Time a disconnected player has to reconnect before the opponent can claim the game
+	AbandonClaimWindow  time.Duration `yaml:"abandon_claim_window" json:"abandon_claim_window" validate:"min=5s"`   // Time the opponent has to claim an abandoned game before it is ended as their win
+	FirstMoveTimeout    time.Duration `yaml:"first_move_timeout" json:"first_move_timeout" validate:"min=10s"`      // Time each player has to make their first move before the game is aborted
+	ArchivedRoomLogs    int           `yaml:"archived_room_logs" json:"archived_room_logs" validate:"min=0"`        // Number of event logs of closed rooms kept in memory for export
+	RoomCapacity        int           `yaml:"room_capacity" json:"room_capacity" validate:"min=1"`                  // Number of rooms above which the server reports itself as not ready
+	DrainDelay          time.Duration `yaml:"drain_delay" json:"drain_delay" validate:"min=0"`                      // Time the server keeps serving after reporting not ready, before shutting down
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout" json:"shutdown_timeout" validate:"min=0"`            // Time to wait for in-flight HTTP requests on shutdown
+
+	AdminToken string `yaml:"admin_token" json:"admin_token"` // Bearer token required by the admin API, which is disabled when empty
 
-	Host = *host
+	TLSCertFile       string        `yaml:"tls_cert_file" json:"tls_cert_file" validate:"required_with=TLSKeyFile"`     // Certificate served over HTTPS, TLS is disabled when empty
+	TLSKeyFile        string        `yaml:"tls_key_file" json:"tls_key_file" validate:"required_with=TLSCertFile"`      // Private key of the certificate
+	TLSReloadInterval time.Duration `yaml:"tls_reload_interval" json:"tls_reload_interval" validate:"min=1s"`           // Interval between checks for a renewed certificate
+	RedirectHost      string        `yaml:"redirect_host" json:"redirect_host" validate:"excluded_without=TLSCertFile"` // Address of a plain HTTP listener that redirects to HTTPS, disabled when empty
 
-	if *prod {
-		AllowedOrigins = allowedProdOrigins
-	} else {
-		AllowedOrigins = allowedDevOrigins
+	RedisURL string `yaml:"redis_url" json:"redis_url"` // Redis compatible server shared by the nodes of a cluster, the server runs on its own when empty
+	NodeID   string `yaml:"node_id" json:"node_id"`     // Name of the server in the cluster, a random one is used when empty
+}
+
+// Returns the configuration used when no other source sets a value.
+func Default() *Config {
+	return &Config{
+		Host:                "localhost:8000",
+		LogLevel:            "info",
+		LogFormat:           "text",
+		AIMoveDelay:         time.Second,
+		AIThinkTimeout:      30 * time.Second,
+		RoomInactiveTimeout: 5 * time.Minute,
+		AbandonGracePeriod:  time.Minute,
+		AbandonClaimWindow:  2 * time.Minute,
+		FirstMoveTimeout:    time.Minute,
+		ArchivedRoomLogs:    100,
+		RoomCapacity:        10000,
+		DrainDelay:          10 * time.Second,
+		ShutdownTimeout:     30 * time.Second,
+		TLSReloadInterval:   time.Minute,
 	}
 }
 
-var (
-	Banner = `    _________             ________
-   / ____/ (_)___        / ____/ /___  ____
-  / /_  / / / __ \______/ /_  / / __ \/ __ \
- / __/ / / / /_/ /_____/ __/ / / /_/ / /_/ /
-/_/   /_/_/ .___/     /_/   /_/\____/ .___/
-         /_/                       /_/      `
+// A configuration value that can be set from a string.
+type field struct {
+	key    string
+	usage  string
+	ptr    any
+	live   bool // Can be changed by a reload without restarting the server
+	secret bool // Hidden when the config is displayed
+}
 
-	Version = "1.1.0"
-	Host    = ":8000"
-
-	AllowedOrigins []string
-	APILogLevel    = log.INFO
-	CorsConfig     = cors.Options{
-		AllowedOrigins:   AllowedOrigins,
-		AllowedMethods:   []string{"GET", "HEAD", "OPTIONS"},
-		AllowedHeaders:   []string{"User-Agent", "Content-Type", "Accept", "Accept-Encoding", "Cache-Control"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}
-
-	AIMoveDelay         = 1      // Delay in seconds before AI makes a move
-	AIThinkTimeout      = 30     // Time in seconds for AI to think before timing out
-	RoomInactiveTimeout = 5 * 60 // Time in seconds before an inactive room is closed
-)
+func (c *Config) fields() []field {
+	return []field{
+		{key: "host", usage: "Host address for the server", ptr: &c.Host},
+		{key: "prod", usage: "Run in production mode", ptr: &c.Prod, live: true},
+		{key: "allowed_origins", usage: "Comma separated list of allowed origins", ptr: &c.AllowedOrigins, live: true},
+		{key: "log_level", usage: "Log level: debug, info, warn or error", ptr: &c.LogLevel, live: true},
+		{key: "log_format", usage: "Log format: text or json", ptr: &c.LogFormat},
+		{key: "ai_move_delay", usage: "Delay before the AI makes a move", ptr: &c.AIMoveDelay, live: true},
+		{key: "ai_think_timeout", usage: "Time the AI has to think before timing out", ptr: &c.AIThinkTimeout, live: true},
+		{key: "room_inactive_timeout", usage: "Time before an inactive room is closed", ptr: &c.RoomInactiveTimeout, live: true},
+		{key: "abandon_grace_period", usage: "Time a disconnected player has to reconnect", ptr: &c.AbandonGracePeriod, live: true},
+		{key: "abandon_claim_window", usage: "Time the opponent has to claim an abandoned game", ptr: &c.AbandonClaimWindow, live: true},
+		{key: "first_move_timeout", usage: "Time each player has to make their first move", ptr: &c.FirstMoveTimeout, live: true},
+		{key: "archived_room_logs", usage: "Number of event logs of closed rooms kept in memory", ptr: &c.ArchivedRoomLogs},
+		{key: "room_capacity", usage: "Number of rooms above which the server is not ready", ptr: &c.RoomCapacity, live: true},
+		{key: "drain_delay", usage: "Time to keep serving after reporting not ready on shutdown", ptr: &c.DrainDelay, live: true},
+		{key: "shutdown_timeout", usage: "Time to wait for in-flight HTTP requests on shutdown", ptr: &c.ShutdownTimeout, live: true},
+		{key: "admin_token", usage: "Bearer token required by the admin API", ptr: &c.AdminToken, live: true, secret: true},
+		{key: "tls_cert_file", usage: "Certificate file served over HTTPS", ptr: &c.TLSCertFile},
+		{key: "tls_key_file", usage: "Private key file of the certificate", ptr: &c.TLSKeyFile},
+		{key: "tls_reload_interval", usage: "Interval between checks for a renewed certificate", ptr: &c.TLSReloadInterval},
+		{key: "redirect_host", usage: "Address of a plain HTTP listener that redirects to HTTPS", ptr: &c.RedirectHost},
+		{key: "redis_url", usage: "URL of the Redis compatible server shared by the nodes of a cluster", ptr: &c.RedisURL, secret: true},
+		{key: "node_id", usage: "Name of the server in the cluster", ptr: &c.NodeID},
+	}
+}
+
+// Parses a string into the value pointed to by ptr.
+func setValue(ptr any, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *[]string:
+		*p = nil
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported config type %T", ptr)
+	}
+	return nil
+}
+
+// Loads the configuration from, in increasing order of precedence, the defaults, the config file,
+// environment variables and command line flags. The config file is set with the -config flag or FLIPFLOP_CONFIG.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	// Flags are applied last, so their values are only collected here
+	fs := flag.NewFlagSet("flipflop", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "Path to a YAML config file")
+	flagValues := map[string]string{}
+	for _, f := range cfg.fields() {
+		collect := func(value string) error {
+			flagValues[f.key] = value
+			return nil
+		}
+
+		// Boolean flags can be passed without a value, like -prod
+		if _, isBool := f.ptr.(*bool); isBool {
+			fs.BoolFunc(flagName(f.key), f.usage, collect)
+		} else {
+			fs.Func(flagName(f.key), f.usage, collect)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range cfg.fields() {
+		envName := EnvPrefix + strings.ToUpper(f.key)
+		if value, ok := os.LookupEnv(envName); ok {
+			if err := setValue(f.ptr, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", envName, err)
+			}
+		}
+	}
+
+	for _, f := range cfg.fields() {
+		if value, ok := flagValues[f.key]; ok {
+			if err := setValue(f.ptr, value); err != nil {
+				return nil, fmt.Errorf("invalid value for -%s: %w", flagName(f.key), err)
+			}
+		}
+	}
+
+	if len(cfg.AllowedOrigins) == 0 {
+		if cfg.Prod {
+			cfg.AllowedOrigins = allowedProdOrigins
+		} else {
+			cfg.AllowedOrigins = allowedDevOrigins
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Returns the name of the flag for a config key.
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// Reads a YAML config file over the current values.
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Checks that every value is within its allowed range.
+func (c *Config) Validate() error {
+	if ok, validationErrors := validator.New().Validate(c); !ok {
+		errs := make([]error, len(validationErrors))
+		for i, ve := range validationErrors {
+			errs[i] = fmt.Errorf("invalid config %s: %s", ve.Field, ve.Msg)
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Returns the slog level matching the configured log level.
+func (c *Config) Level() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// Checks if the server is configured to serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// Checks if clients from the given origin are allowed to connect.
+func (c *Config) AllowsOrigin(origin string) bool {
+	for _, allowedOrigin := range c.AllowedOrigins {
+		if allowedOrigin == "*" || allowedOrigin == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns every setting by its key, with durations in a readable form and secrets redacted.
+func (c *Config) Values() map[string]any {
+	values := make(map[string]any)
+	for _, f := range c.fields() {
+		switch p := f.ptr.(type) {
+		case *time.Duration:
+			values[f.key] = p.String()
+		case *string:
+			if f.secret && *p != "" {
+				values[f.key] = "[redacted]"
+			} else {
+				values[f.key] = *p
+			}
+		default:
+			values[f.key] = reflect.ValueOf(f.ptr).Elem().Interface()
+		}
+	}
+	return values
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsTooShortDurations(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(*Config)
+		wantErr string
+	}{
+		{name: "defaults", set: func(c *Config) {}},
+		{name: "no AI move delay", set: func(c *Config) { c.AIMoveDelay = 0 }},
+		{name: "AI think timeout in nanoseconds", set: func(c *Config) { c.AIThinkTimeout = 1 }, wantErr: "ai_think_timeout: Minimum duration is 1s"},
+		{name: "room inactive timeout", set: func(c *Config) { c.RoomInactiveTimeout = 30 * time.Second }, wantErr: "room_inactive_timeout"},
+		{name: "abandon grace period", set: func(c *Config) { c.AbandonGracePeriod = time.Second }, wantErr: "abandon_grace_period"},
+		{name: "abandon claim window", set: func(c *Config) { c.AbandonClaimWindow = time.Millisecond }, wantErr: "abandon_claim_window"},
+		{name: "first move timeout", set: func(c *Config) { c.FirstMoveTimeout = 5 * time.Second }, wantErr: "first_move_timeout"},
+		{name: "shortest first move timeout", set: func(c *Config) { c.FirstMoveTimeout = 10 * time.Second }},
+		{name: "TLS reload interval", set: func(c *Config) { c.TLSReloadInterval = time.Millisecond }, wantErr: "tls_reload_interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.set(cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got error %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got error %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
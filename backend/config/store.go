@@ -0,0 +1,99 @@
+package config
+
+import (
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-chi/cors"
+)
+
+// Holds the effective configuration and swaps it atomically when the config is reloaded.
+// A loaded config is never modified, readers should call Load every time they need a value.
+type Store struct {
+	current  atomic.Pointer[Config]
+	args     []string
+	logger   *slog.Logger
+	mu       sync.Mutex // Serializes reloads
+	onReload []func(*Config)
+}
+
+// Outcome of a config reload.
+type ReloadResult struct {
+	Applied  []string `json:"applied"`  // Keys of the settings that changed
+	Rejected []string `json:"rejected"` // Keys of the settings that changed but require a restart
+}
+
+// Returns a store holding cfg. The args are the command line flags used to load it, which are applied again on every reload.
+func NewStore(cfg *Config, args []string, logger *slog.Logger) *Store {
+	s := &Store{args: args, logger: logger}
+	s.current.Store(cfg)
+	return s
+}
+
+// Returns the current configuration.
+func (s *Store) Load() *Config {
+	return s.current.Load()
+}
+
+// Registers a function called with the new configuration after every reload.
+// Must be called before the store is shared.
+func (s *Store) OnReload(fn func(*Config)) {
+	s.onReload = append(s.onReload, fn)
+}
+
+// Loads the configuration again from its file, environment and flags.
+// Settings that can only be set at startup keep their current value and are reported as rejected.
+func (s *Store) Reload() (*ReloadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, err := Load(s.args)
+	if err != nil {
+		s.logger.Error("Config reload failed, keeping the current config", "error", err)
+		return nil, err
+	}
+
+	current := s.current.Load()
+	result := &ReloadResult{Applied: []string{}, Rejected: []string{}}
+	currentFields, nextFields := current.fields(), next.fields()
+	for i, f := range nextFields {
+		currentValue := reflect.ValueOf(currentFields[i].ptr).Elem()
+		nextValue := reflect.ValueOf(f.ptr).Elem()
+		if reflect.DeepEqual(currentValue.Interface(), nextValue.Interface()) {
+			continue
+		}
+
+		if !f.live {
+			s.logger.Warn("Config change rejected, this setting requires a restart", "setting", f.key)
+			nextValue.Set(currentValue)
+			result.Rejected = append(result.Rejected, f.key)
+			continue
+		}
+		result.Applied = append(result.Applied, f.key)
+	}
+
+	s.current.Store(next)
+	for _, fn := range s.onReload {
+		fn(next)
+	}
+
+	s.logger.Info("Config reloaded", "applied", result.Applied, "rejected", result.Rejected)
+	return result, nil
+}
+
+// Returns the CORS options for the REST API. Allowed origins follow the current config.
+func (s *Store) CorsOptions() cors.Options {
+	return cors.Options{
+		AllowOriginFunc: func(r *http.Request, origin string) bool {
+			return s.Load().AllowsOrigin(origin)
+		},
+		AllowedMethods:   []string{"GET", "HEAD", "OPTIONS"},
+		AllowedHeaders:   []string{"User-Agent", "Content-Type", "Accept", "Accept-Encoding", "Cache-Control"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	}
+}
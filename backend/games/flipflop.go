@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/CDavidSV/online-flip-flop/config"
 	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
-	"github.com/labstack/gommon/log"
 )
 
 type FlipFlopType int
@@ -398,8 +396,6 @@ func (g *FlipFlop) ApplyMove(move json.RawMessage) error {
 	fen := encodeBoardState(g.Board, g.currentTurn)
 	g.boardHistory = append(g.boardHistory, fen)
 
-	g.printGameState(fen)
-
 	// Check for game end conditions
 	// After the move, check if the current player has any pieces in their goal
 	pieceInGoal := g.Board[player.Goal.Row][player.Goal.Col]
@@ -479,27 +475,23 @@ func (g *FlipFlop) UndoLastMove() {
 	g.Player2.ValidMoves = lastMove.player2ValidMoves
 }
 
-func (g *FlipFlop) printGameState(fenStr string) {
-	if config.APILogLevel > log.DEBUG {
-		return
-	}
-
+// Returns the board drawn as a grid with the rows and columns labeled, for debug logs.
+func (g *FlipFlop) DrawBoard() string {
 	boardSize := int(g.Type)
 
-	turn := fenStr[len(fenStr)-1]
-	fmt.Printf("Current Turn: %s\n", map[byte]string{'1': "White", '2': "Black"}[turn])
+	fenStr := g.GetBoardString()
 	rows := strings.Split(fenStr[:len(fenStr)-1], "/")
 
+	var board strings.Builder
 	for row, data := range rows {
-		fmt.Printf("%d| %s\n", boardSize-row, strings.Join(strings.Split(data, ""), " "))
+		fmt.Fprintf(&board, "\n%d| %s", boardSize-row, strings.Join(strings.Split(data, ""), " "))
 	}
 
-	fmt.Println(" +" + strings.Repeat("-", boardSize*2+1))
-	fmt.Print("  ")
+	board.WriteString("\n +" + strings.Repeat("-", boardSize*2+1) + "\n  ")
 	for i := range boardSize {
-		fmt.Printf(" %c", 'A'+i)
+		fmt.Fprintf(&board, " %c", 'A'+i)
 	}
-	fmt.Println("")
+	return board.String()
 }
 
 func (g *FlipFlop) CurrentTurn() PlayerSide {
@@ -578,7 +570,5 @@ func NewFlipFlopGame(flipFlopType FlipFlopType) *FlipFlop {
 	game.boardHistory = append(game.boardHistory, initialState)
 	game.positionCounts[initialState] = 1 // Initial position count is 1
 
-	game.printGameState(initialState)
-
 	return game
 }
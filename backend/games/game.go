@@ -41,6 +41,9 @@ type Game interface {
 	// Returns a string representation of the game board.
 	GetBoardString() string
 
+	// Returns the board drawn as a grid with the rows and columns labeled, for debug logs.
+	DrawBoard() string
+
 	// Returns whether the game has ended.
 	IsGameEnded() bool
 
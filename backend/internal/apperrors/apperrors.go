@@ -37,6 +37,23 @@ var (
 	ErrInvalidGameMode      = errors.New("invalid_game_mode")
 	ErrInvalidAIDifficulty  = errors.New("invalid_ai_difficulty")
 	ErrRoomFull             = errors.New("room_full")
+	ErrClaimNotAvailable    = errors.New("claim_not_available")
+	ErrAbortNotAllowed      = errors.New("abort_not_allowed")
+	ErrInvalidEventLog      = errors.New("invalid_event_log")
+	ErrServerDraining       = errors.New("server_draining")
+	ErrServerAtCapacity     = errors.New("server_at_capacity")
+	ErrCleanupJobStalled    = errors.New("cleanup_job_stalled")
+	ErrAdminDisabled        = errors.New("admin_api_disabled")
+	ErrInvalidAdminToken    = errors.New("invalid_admin_token")
+	ErrConfigReloadFailed   = errors.New("config_reload_failed")
+	ErrPeerClosed           = errors.New("peer_closed")
+	ErrPeerBufferFull       = errors.New("peer_buffer_full")
+	ErrUpgradeRequired      = errors.New("upgrade_required")
+	ErrUnsupportedEncoding  = errors.New("unsupported_encoding")
+	ErrRoomTaken            = errors.New("room_taken")
+	ErrBusFull              = errors.New("bus_full")
+	ErrRoomIDRequired       = errors.New("room_id_required")
+	ErrNodeTimeout          = errors.New("node_timeout")
 )
 
 // Returns an AppError instance with the given error code and optional details.
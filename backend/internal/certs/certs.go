@@ -0,0 +1,100 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Serves a TLS certificate loaded from disk and reloads it when the files change,
+// so renewed certificates are picked up without restarting the server.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+}
+
+// Loads the certificate and returns a reloader serving it.
+func NewReloader(certFile, keyFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Returns the current certificate, used as the GetCertificate function of a tls.Config.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Loads the certificate and key files and replaces the served certificate.
+func (r *Reloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+// Returns the most recent modification time of the certificate and key files.
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Checks the files for changes every interval until the context is cancelled.
+// If a changed certificate cannot be loaded, the previous one keeps being served.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := r.latestModTime()
+			if err != nil {
+				r.logger.Error("Failed to check TLS certificate", "error", err)
+				continue
+			}
+			if !modTime.After(r.modTime) {
+				continue
+			}
+
+			if err := r.reload(); err != nil {
+				r.logger.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+				continue
+			}
+			r.logger.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+		}
+	}
+}
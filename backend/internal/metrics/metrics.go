@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "flipflop"
+
+var (
+	Connections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_connections",
+		Help:      "Number of open websocket connections.",
+	})
+
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_received_total",
+		Help:      "Number of websocket messages received, by message type.",
+	}, []string{"type"})
+
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_sent_total",
+		Help:      "Number of websocket messages written to clients, by message type. Broadcasts are counted for every recipient.",
+	}, []string{"type"})
+
+	OutboundDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_outbound_dropped_total",
+		Help:      "Number of times a full outbound queue dropped a message or disconnected a slow client, by policy.",
+	}, []string{"policy"})
+
+	GamesStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "games_started_total",
+		Help:      "Number of games started, including rematches.",
+	}, []string{"game_mode", "game_type"})
+
+	GamesFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "games_finished_total",
+		Help:      "Number of games finished, by end reason.",
+	}, []string{"game_mode", "reason"})
+
+	MovesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moves_processed_total",
+		Help:      "Number of moves applied, including AI moves.",
+	}, []string{"game_mode"})
+
+	AIThinkTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ai_think_seconds",
+		Help:      "Time the AI takes to find a move, by difficulty.",
+		Buckets:   []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"difficulty"})
+
+	BroadcastLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_fanout_seconds",
+		Help:      "Time taken to hand a broadcast message to every connection in a room.",
+		Buckets:   prometheus.ExponentialBuckets(0.00005, 4, 8),
+	})
+)
+
+// Labels of the room gauge.
+type RoomLabels struct {
+	Status   string
+	GameMode string
+}
+
+var roomsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "rooms"),
+	"Number of rooms, by status and game mode.",
+	[]string{"status", "game_mode"}, nil,
+)
+
+// Collects the room gauge at scrape time so that it never drifts from the actual rooms.
+type roomCollector struct {
+	count func() map[RoomLabels]int
+}
+
+func (c roomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+}
+
+func (c roomCollector) Collect(ch chan<- prometheus.Metric) {
+	for labels, n := range c.count() {
+		ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(n), labels.Status, labels.GameMode)
+	}
+}
+
+// Registers the function used to count the rooms on every scrape.
+func RegisterRoomCounter(count func() map[RoomLabels]int) {
+	prometheus.MustRegister(roomCollector{count: count})
+}
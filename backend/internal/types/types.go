@@ -1,3 +0,0 @@
-package types
-
-type JSONMap map[string]any
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -44,6 +45,12 @@ func getErrorMsg(tag, param string) string {
 		return fmt.Sprintf("Maximum length is %s", param)
 	case "oneof":
 		return fmt.Sprintf("Value must be one of the following: %s", param)
+	case "excluded_with":
+		return fmt.Sprintf("Cannot be set together with %s", param)
+	case "excluded_without":
+		return fmt.Sprintf("Can only be set together with %s", param)
+	case "required_with":
+		return fmt.Sprintf("This field is required when %s is set", param)
 	default:
 		return fmt.Sprintf("Failed on the '%s' validation tag", tag)
 	}
@@ -59,6 +66,9 @@ func (cv *CustomValidator) Validate(i any) (bool, []ValidationError) {
 				Field: ve.Field(),
 				Msg:   getErrorMsg(ve.Tag(), ve.Param()),
 			}
+			if ve.Type() == reflect.TypeFor[time.Duration]() && ve.Tag() == "min" {
+				errorsResponse[i].Msg = fmt.Sprintf("Minimum duration is %s", ve.Param())
+			}
 		}
 
 		return false, errorsResponse
@@ -1,42 +1,197 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/CDavidSV/online-flip-flop/api"
+	"github.com/CDavidSV/online-flip-flop/cluster"
 	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/internal/certs"
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
 	"github.com/CDavidSV/online-flip-flop/ws"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	r := chi.NewRouter()
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Level())
+	logger := newLogger(os.Stdout, cfg.LogFormat, logLevel)
+	slog.SetDefault(logger)
+
+	settings := config.NewStore(cfg, os.Args[1:], logger)
+	settings.OnReload(func(cfg *config.Config) {
+		logLevel.Set(cfg.Level())
+	})
 
-	fmt.Println(config.Banner)
-	fmt.Println("Version:", config.Version)
-	fmt.Println("Server listening on", config.Host)
+	// The banner would break log parsers reading JSON lines
+	if cfg.LogFormat == "text" {
+		fmt.Println(config.Banner)
+	}
+	logger.Info("Server listening", "host", cfg.Host, "tls", cfg.TLSEnabled(), "version", config.Version)
 
 	// Middleware
-	r.Use(Logger)
+	r.Use(middleware.RequestID)
+	r.Use(RequestLogger(logger))
 	r.Use(middleware.Recoverer)
-	r.Use(cors.Handler(config.CorsConfig))
+	r.Use(cors.Handler(settings.CorsOptions()))
 
 	// Register WebSocket handler
-	gameServer := ws.NewGameServer(logger)
-	gameServer.Start()
+	gameServer := ws.NewGameServer(settings, logger)
+	if cfg.RedisURL != "" {
+		redisClient, err := cluster.NewRedisClient(cfg.RedisURL)
+		if err != nil {
+			logger.Error("Invalid Redis URL", "error", err)
+			os.Exit(1)
+		}
+		defer redisClient.Close()
+
+		bus := cluster.NewRedisBus(redisClient, logger)
+		defer bus.Close()
+		gameServer.UseCluster(cfg.NodeID, cluster.NewRedisDirectory(redisClient), bus)
+	}
+	if err := gameServer.Start(); err != nil {
+		logger.Error("Failed to start game server", "error", err)
+		os.Exit(1)
+	}
 	defer gameServer.Stop()
+	logger.Info("Game server started", "node_id", gameServer.NodeID(), "cluster", cfg.RedisURL != "")
+	metrics.RegisterRoomCounter(gameServer.CountRooms)
 
 	// Wsocket endpoint
 	r.Get("/ws", ws.WSHandler(gameServer))
 
+	// REST API
+	r.Get("/info", api.ServerInfoHandler(gameServer))
+	r.Get("/rooms", api.ListRoomsHandler(gameServer))
+	r.Get("/rooms/{roomID}", api.RoomHandler(gameServer))
+	r.Get("/rooms/{roomID}/history", api.GameHistoryHandler(gameServer))
+	r.Get("/rooms/{roomID}/events", api.RoomEventsHandler(gameServer))
+
+	// Health checks
+	r.Get("/healthz", api.HealthHandler(gameServer))
+	r.Get("/readyz", api.ReadyHandler(gameServer))
+
+	// Admin API
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(api.AdminAuth(settings, logger))
+		r.Get("/config", api.ConfigHandler(settings, logger))
+		r.Post("/config/reload", api.ReloadConfigHandler(settings, logger))
+		r.Get("/rooms", api.AdminRoomsHandler(gameServer, logger))
+		r.Get("/rooms/{roomID}", api.AdminRoomHandler(gameServer, logger))
+		r.Get("/rooms/{roomID}/log", api.AdminRoomLogHandler(gameServer, logger))
+		r.Get("/rooms/{roomID}/replay", api.AdminReplayRoomHandler(gameServer, logger))
+		r.Post("/rooms/{roomID}/close", api.CloseRoomHandler(gameServer, logger))
+		r.Post("/clients/{clientID}/kick", api.KickClientHandler(gameServer, logger))
+		r.Post("/announcements", api.AnnouncementHandler(gameServer, logger))
+	})
+
+	// Prometheus metrics
+	r.Handle("/metrics", promhttp.Handler())
+
 	// Start listening
-	log.Fatal(http.ListenAndServe(config.Host, r))
+	// Event streams never become idle, so their requests are cancelled when the server shuts down
+	streamCtx, stopStreams := context.WithCancel(context.Background())
+	httpServer := &http.Server{
+		Addr:        cfg.Host,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return streamCtx },
+	}
+	httpServer.RegisterOnShutdown(stopStreams)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var redirectServer *http.Server
+	if cfg.TLSEnabled() {
+		certReloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		go certReloader.Watch(ctx, cfg.TLSReloadInterval)
+
+		// Websocket upgrades hijack the connection, which is only possible over HTTP/1.1
+		httpServer.Protocols = new(http.Protocols)
+		httpServer.Protocols.SetHTTP1(true)
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certReloader.GetCertificate,
+		}
+
+		if cfg.RedirectHost != "" {
+			redirectServer = &http.Server{
+				Addr:    cfg.RedirectHost,
+				Handler: redirectToHTTPS(cfg.Host),
+			}
+			go func() {
+				if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Error("Redirect listener failed", "error", err)
+					os.Exit(1)
+				}
+			}()
+		}
+	}
+
+	go func() {
+		var err error
+		if cfg.TLSEnabled() {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Reload the config on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			settings.Reload()
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so load balancers stop sending new clients before the listener closes
+	gameServer.Drain()
+	time.Sleep(settings.Load().DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.Load().ShutdownTimeout)
+	defer cancel()
+
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("HTTP server shutdown failed", "error", err)
+	}
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// Redirects every request to the same URL over HTTPS, on the port of the TLS listener.
+func redirectToHTTPS(tlsHost string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsHost)
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(res, req, target, http.StatusPermanentRedirect)
+	})
+}
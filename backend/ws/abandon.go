@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+type AbandonClaim string
+
+const (
+	ClaimWin  AbandonClaim = "win"  // Claim the game as won.
+	ClaimDraw AbandonClaim = "draw" // Claim the game as a draw.
+)
+
+// Checks if a game has been started and has not finished yet.
+// Must be called from the room goroutine.
+func (gr *GameRoom) gameInProgress() bool {
+	return gr.gameStarted && gr.status != StatusEnded && gr.status != StatusClosed
+}
+
+// Returns the other player in the room.
+// Must be called from the room goroutine.
+func (gr *GameRoom) getOpponent(player *PlayerSlot) *PlayerSlot {
+	if player == gr.player1 {
+		return gr.player2
+	}
+	return gr.player1
+}
+
+// Checks if the player has been disconnected for longer than the grace period.
+func (p *PlayerSlot) abandonExpired() bool {
+	return !p.IsActive && p.AbandonDeadline != nil && !time.Now().Before(*p.AbandonDeadline)
+}
+
+// Returns the time a disconnected player has to come back, as chosen for the room or from the current settings.
+// Must be called from the room goroutine.
+func (gr *GameRoom) abandonGracePeriod() time.Duration {
+	if gr.abandonGrace > 0 {
+		return gr.abandonGrace
+	}
+	return gr.settings.Load().AbandonGracePeriod
+}
+
+// Starts the grace period for a player that disconnected during a game.
+// Must be called from the room goroutine.
+func (gr *GameRoom) startAbandonTimer(player *PlayerSlot) {
+	gr.stopAbandonTimer(player)
+
+	grace := gr.abandonGracePeriod()
+	deadline := time.Now().Add(grace)
+	player.AbandonDeadline = &deadline
+
+	playerID := player.ID
+	player.abandonTimer = time.AfterFunc(grace, func() {
+		gr.do(func() { gr.onAbandonTimeout(playerID) })
+	})
+}
+
+// Stops the grace period of a player, and the claim window that follows it.
+// Must be called from the room goroutine.
+func (gr *GameRoom) stopAbandonTimer(player *PlayerSlot) {
+	if player.abandonTimer != nil {
+		player.abandonTimer.Stop()
+		player.abandonTimer = nil
+	}
+	if player.claimTimer != nil {
+		player.claimTimer.Stop()
+		player.claimTimer = nil
+	}
+	player.AbandonDeadline = nil
+}
+
+// Stops the grace period of both players, used when the game ends.
+// Must be called from the room goroutine.
+func (gr *GameRoom) stopAbandonTimers() {
+	for _, player := range []*PlayerSlot{gr.player1, gr.player2} {
+		if player != nil {
+			gr.stopAbandonTimer(player)
+		}
+	}
+}
+
+// Called when the grace period of a disconnected player runs out.
+// Lets the opponent claim the game, or ends it if both players are gone. Must be called from the room goroutine.
+func (gr *GameRoom) onAbandonTimeout(playerID string) {
+	player := gr.getPlayer(playerID)
+	if player == nil || !player.abandonExpired() || !gr.gameInProgress() {
+		return
+	}
+
+	opponent := gr.getOpponent(player)
+	if opponent.IsActive {
+		gr.broadcastGameUpdate(MsgTypeAbandonClaimable, PlayerUpdate{PlayerID: playerID}, nil)
+		player.claimTimer = time.AfterFunc(gr.settings.Load().AbandonClaimWindow, func() {
+			gr.do(func() { gr.onClaimTimeout(playerID) })
+		})
+		return
+	}
+
+	// Nobody is left to claim the game. If the opponent is still within their own grace period, their timer will end it.
+	if opponent.abandonExpired() {
+		gr.endGame(EndReasonAbandoned, -1)
+	}
+}
+
+// Called when the opponent of a player who abandoned the game did not claim it in time.
+// Ends the game as a win for the opponent. Must be called from the room goroutine.
+func (gr *GameRoom) onClaimTimeout(playerID string) {
+	player := gr.getPlayer(playerID)
+	if player == nil || !player.abandonExpired() || !gr.gameInProgress() {
+		return
+	}
+
+	// An opponent who left in the meantime is handled by their own grace period
+	opponent := gr.getOpponent(player)
+	if opponent == nil || !opponent.IsActive {
+		return
+	}
+
+	gr.endGame(EndReasonAbandoned, opponent.Color)
+}
+
+// Handles a claim from a player whose opponent has abandoned the game, ending it as a win or a draw.
+func (gr *GameRoom) ClaimAbandonment(clientID string, claim AbandonClaim) (err error) {
+	gr.do(func() { err = gr.claimAbandonment(clientID, claim) })
+	return err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) claimAbandonment(clientID string, claim AbandonClaim) error {
+	player := gr.getPlayer(clientID)
+	if player == nil || !player.IsActive {
+		return apperrors.ErrUnauthorizedAction
+	}
+
+	if !gr.gameInProgress() {
+		return apperrors.ErrGameEnded
+	}
+
+	opponent := gr.getOpponent(player)
+	if opponent == nil || !opponent.abandonExpired() {
+		return apperrors.ErrClaimNotAvailable
+	}
+
+	var winner games.PlayerSide = -1
+	if claim == ClaimWin {
+		winner = player.Color
+	}
+
+	gr.recordEvent(EventAbandonClaimed, clientID, abandonClaimedEvent{Result: claim})
+	gr.endGame(EndReasonAbandoned, winner)
+	return nil
+}
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+)
+
+func shortAbandonTimers(cfg *config.Config) {
+	cfg.AbandonGracePeriod = 20 * time.Millisecond
+	cfg.AbandonClaimWindow = 20 * time.Millisecond
+}
+
+func TestUnclaimedAbandonmentEndsAsOpponentWin(t *testing.T) {
+	srv := newTestServer(t, shortAbandonTimers)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	_, state := startMultiplayerGame(t, host, guest)
+
+	srv.Disconnect(guest.peer, nil)
+	host.expect(MsgTypeAbandonClaimable)
+
+	var ended GameEnded
+	host.expectPayload(MsgTypeGameEnd, &ended)
+	if ended.Reason != EndReasonAbandoned {
+		t.Fatalf("got reason %q, want %q", ended.Reason, EndReasonAbandoned)
+	}
+	if want := colorOf(t, state, host.id); ended.Winner == nil || *ended.Winner != want {
+		t.Fatalf("got winner %v, want %v", ended.Winner, want)
+	}
+}
+
+func TestClaimedAbandonmentIsNotEndedAgain(t *testing.T) {
+	srv := newTestServer(t, shortAbandonTimers)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	roomID, _ := startMultiplayerGame(t, host, guest)
+
+	srv.Disconnect(guest.peer, nil)
+	host.expect(MsgTypeAbandonClaimable)
+	host.send(MsgTypeClaimAbandon, roomID, ClaimAbandon{Result: ClaimDraw})
+
+	var ended GameEnded
+	host.expectPayload(MsgTypeGameEnd, &ended)
+	if ended.Winner != nil {
+		t.Fatalf("got winner %v for a draw claim", *ended.Winner)
+	}
+	host.expectNone(MsgTypeGameEnd, 100*time.Millisecond)
+}
+
+func TestReturningPlayerCancelsClaimWindow(t *testing.T) {
+	srv := newTestServer(t, func(cfg *config.Config) {
+		cfg.AbandonGracePeriod = 20 * time.Millisecond
+		cfg.AbandonClaimWindow = 100 * time.Millisecond
+	})
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	roomID, _ := startMultiplayerGame(t, host, guest)
+
+	srv.Disconnect(guest.peer, nil)
+	host.expect(MsgTypeAbandonClaimable)
+
+	// The guest comes back with the same client ID before the opponent claims the game
+	back := &testClient{t: t, srv: srv, peer: NewMemoryPeer(guest.id, 256), id: guest.id}
+	if err := srv.Connect(back.peer); err != nil {
+		t.Fatalf("reconnecting: %v", err)
+	}
+	back.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID})
+	back.expect(MsgTypeJoinedRoom)
+
+	host.expect(MsgPlayerRejoined)
+	host.expectNone(MsgTypeGameEnd, 200*time.Millisecond)
+}
+
+func TestReloadedGracePeriodAppliesToRunningRooms(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	startMultiplayerGame(t, host, guest)
+
+	t.Setenv(config.EnvPrefix+"ABANDON_GRACE_PERIOD", "5m")
+	if _, err := srv.cfg.Reload(); err != nil {
+		t.Fatalf("reloading config: %v", err)
+	}
+
+	srv.Disconnect(guest.peer, nil)
+	var left PlayerLeft
+	host.expectPayload(MsgPlayerLeftRoom, &left)
+	if left.GracePeriod != 300 {
+		t.Fatalf("got grace period %d, want 300", left.GracePeriod)
+	}
+}
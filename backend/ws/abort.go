@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+// Starts the timer for the next pending first move, or stops it once both players have moved.
+// Only multiplayer games are aborted automatically. Must be called from the room goroutine.
+func (gr *GameRoom) updateAbortTimer() {
+	gr.stopAbortTimer()
+
+	movesPlayed := len(gr.Game.GetMoveHistory())
+	if gr.GameMode != "multiplayer" || movesPlayed >= 2 {
+		return
+	}
+
+	gr.abortTimer = time.AfterFunc(gr.settings.Load().FirstMoveTimeout, func() {
+		gr.do(func() { gr.onAbortTimeout(movesPlayed) })
+	})
+}
+
+// Stops the first move timer.
+// Must be called from the room goroutine.
+func (gr *GameRoom) stopAbortTimer() {
+	if gr.abortTimer != nil {
+		gr.abortTimer.Stop()
+		gr.abortTimer = nil
+	}
+}
+
+// Called when a player did not make their first move in time.
+// movesPlayed is the number of moves that had been made when the timer was started.
+// Must be called from the room goroutine.
+func (gr *GameRoom) onAbortTimeout(movesPlayed int) {
+	if !gr.gameInProgress() || len(gr.Game.GetMoveHistory()) != movesPlayed {
+		return
+	}
+
+	gr.endGame(EndReasonAborted, -1)
+}
+
+// Handles an abort request from a player. A game can only be aborted by a player that has not moved yet.
+// Aborted games have no result and do not count towards a series.
+func (gr *GameRoom) HandleAbort(clientID string) (err error) {
+	gr.do(func() { err = gr.handleAbort(clientID) })
+	return err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) handleAbort(clientID string) error {
+	player := gr.getPlayer(clientID)
+	if player == nil {
+		return apperrors.ErrUnauthorizedAction
+	}
+
+	switch {
+	case gr.status == StatusClosed:
+		return apperrors.ErrRoomClosed
+	case gr.status == StatusEnded:
+		return apperrors.ErrGameEnded
+	case !gr.gameInProgress():
+		return apperrors.ErrGameNotStarted
+	}
+
+	for _, move := range gr.Game.GetMoveHistory() {
+		if move.Player == player.Color {
+			return apperrors.ErrAbortNotAllowed
+		}
+	}
+
+	gr.recordEvent(EventAbort, clientID, nil)
+	gr.endGame(EndReasonAborted, -1)
+	return nil
+}
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+const testFirstMoveTimeout = 100 * time.Millisecond
+
+// Plays the first moves of a game. Returns the players by color.
+func playFirstMoves(t *testing.T, host, guest *testClient, roomID string, state GameState, moves int) map[games.PlayerSide]*testClient {
+	t.Helper()
+
+	mirror := games.NewFlipFlopGame(games.FlipFlop3x3)
+	players := map[games.PlayerSide]*testClient{
+		colorOf(t, state, host.id):  host,
+		colorOf(t, state, guest.id): guest,
+	}
+	for range moves {
+		mover := players[mirror.CurrentTurn()]
+		move := firstValidMove(t, mirror)
+		applyMove(t, mirror, move)
+		mover.send(MsgTypeMove, roomID, move)
+		mover.expect(MsgTypeAck)
+	}
+	return players
+}
+
+func TestGamesWithoutFirstMovesAreAborted(t *testing.T) {
+	tests := []struct {
+		name        string
+		moves       int
+		wantAborted bool
+	}{
+		{name: "nobody moved", moves: 0, wantAborted: true},
+		{name: "second player did not move", moves: 1, wantAborted: true},
+		{name: "both players moved", moves: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, func(cfg *config.Config) { cfg.FirstMoveTimeout = testFirstMoveTimeout })
+			host := connectTestClient(t, srv)
+			guest := connectTestClient(t, srv)
+			roomID, state := startMultiplayerGame(t, host, guest)
+			playFirstMoves(t, host, guest, roomID, state, tt.moves)
+
+			if !tt.wantAborted {
+				host.expectNone(MsgTypeGameEnd, 3*testFirstMoveTimeout)
+				return
+			}
+			for _, player := range []*testClient{host, guest} {
+				var ended GameEnded
+				player.expectPayload(MsgTypeGameEnd, &ended)
+				if ended.Reason != EndReasonAborted || ended.Winner != nil {
+					t.Fatalf("got game ended with reason %q and winner %v, want an abort without winner", ended.Reason, ended.Winner)
+				}
+			}
+		})
+	}
+}
+
+func TestOnlyPlayersWhoHaveNotMovedCanAbort(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	roomID, state := startMultiplayerGame(t, host, guest)
+	players := playFirstMoves(t, host, guest, roomID, state, 1)
+
+	players[games.COLOR_WHITE].send(MsgTypeAbort, roomID, nil)
+	players[games.COLOR_WHITE].expectError(apperrors.ErrAbortNotAllowed.Error())
+
+	players[games.COLOR_BLACK].send(MsgTypeAbort, roomID, nil)
+	var ended GameEnded
+	players[games.COLOR_WHITE].expectPayload(MsgTypeGameEnd, &ended)
+	if ended.Reason != EndReasonAborted || ended.Winner != nil {
+		t.Fatalf("got game ended with reason %q and winner %v, want an abort without winner", ended.Reason, ended.Winner)
+	}
+}
@@ -0,0 +1,170 @@
+package ws
+
+import (
+	"slices"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+const CloseKicked uint16 = 1008 // Close code sent to clients kicked out by an operator
+
+// A client connected to a room.
+type ClientInfo struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	IsSpectator bool   `json:"is_spectator"`
+}
+
+// Full view of a room for operators, including the live game state and chat history.
+type RoomDetails struct {
+	RoomSummary
+	InactiveSince     *time.Time     `json:"inactive_since,omitempty"` // Set while no player is connected
+	Clients           []ClientInfo   `json:"clients"`
+	GameState         GameState      `json:"game_state"`
+	Result            *GameResult    `json:"result,omitempty"`
+	PlayerMessages    []SavedMessage `json:"player_messages"`
+	SpectatorMessages []SavedMessage `json:"spectator_messages"`
+}
+
+// Returns the full state of the room, the game state is never delayed.
+// Chat history is only included when withChat is true.
+func (gr *GameRoom) Details(withChat bool) (details RoomDetails) {
+	gr.do(func() { details = gr.details(withChat) })
+	return details
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) details(withChat bool) RoomDetails {
+	details := RoomDetails{
+		RoomSummary: gr.summary(),
+		Clients:     make([]ClientInfo, 0, len(gr.conns)),
+		GameState:   gr.gameState(),
+	}
+
+	if gr.playersInactive() {
+		inactiveSince := gr.lastInactiveTime
+		details.InactiveSince = &inactiveSince
+	}
+
+	if gr.result != nil {
+		result := *gr.result
+		details.Result = &result
+	}
+
+	for _, conn := range gr.conns {
+		details.Clients = append(details.Clients, ClientInfo{
+			ID:          conn.ID,
+			Username:    conn.Username,
+			IsSpectator: conn.isSpectator,
+		})
+	}
+
+	if withChat {
+		details.PlayerMessages = slices.Clone(gr.playerMessages)
+		details.SpectatorMessages = slices.Clone(gr.spectatorMessages)
+	}
+
+	return details
+}
+
+// Closes the room on behalf of an operator and kicks out every client with the given reason.
+func (gr *GameRoom) Close(reason string) (err error) {
+	gr.do(func() { err = gr.close(reason) })
+	return err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) close(reason string) error {
+	if gr.status == StatusClosed {
+		return apperrors.ErrRoomClosed
+	}
+
+	gr.closeRoom(reason)
+	return nil
+}
+
+// Removes a client from the room, notifying them with the given reason.
+// Returns the peer of the kicked client.
+func (gr *GameRoom) Kick(clientID, reason string) (peer Peer, err error) {
+	gr.do(func() { peer, err = gr.kick(clientID, reason) })
+	return peer, err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) kick(clientID, reason string) (Peer, error) {
+	client, ok := gr.conns[clientID]
+	if !ok {
+		return nil, apperrors.ErrClientNotFound
+	}
+
+	sendMessage(client.conn, OutgoingMessage{Type: MsgTypeKicked, RoomID: gr.ID, Payload: Kicked{Reason: reason}}, nil)
+
+	gr.leaveRoom(clientID)
+	return client.conn, nil
+}
+
+// Returns the details of every room held here, without chat history.
+func (s *Server) localRoomDetails() []RoomDetails {
+	rooms := make([]RoomDetails, 0, s.rooms.Len())
+	s.rooms.Range(func(key string, room *GameRoom) bool {
+		rooms = append(rooms, room.Details(false))
+		return true
+	})
+	return rooms
+}
+
+// Force-closes a room held here and removes it from the server.
+func (s *Server) closeRoom(roomID, reason string) error {
+	room := s.GetGameRoom(roomID)
+	if room == nil {
+		return apperrors.ErrRoomNotFound
+	}
+
+	if err := room.Close(reason); err != nil {
+		return err
+	}
+
+	s.DeleteGameRoom(room)
+	return nil
+}
+
+// Removes a client connected here from every room they are in and closes their connection.
+// Rooms of other nodes are left once the connection closes.
+func (s *Server) kickClient(clientID, reason string) error {
+	peer, ok := s.clients.Load(clientID)
+	if !ok {
+		return apperrors.ErrClientNotFound
+	}
+
+	rooms := roomsOf(peer)
+	kicked := rooms.rooms()
+	for _, room := range kicked {
+		rooms.remove(room.ID)
+		room.Kick(clientID, reason)
+		if room.IsClosed() {
+			s.DeleteGameRoom(room)
+		}
+	}
+	if len(kicked) == 0 {
+		sendMessageSync(peer, OutgoingMessage{Type: MsgTypeKicked, Payload: Kicked{Reason: reason}})
+	}
+
+	peer.Close(CloseKicked, reason)
+	return nil
+}
+
+// Sends an announcement to every client connected here.
+// Returns the number of clients the announcement was sent to.
+func (s *Server) announce(message string) int {
+	broadcaster := newBroadcaster(MsgTypeAnnouncement, NewMessage(MsgTypeAnnouncement, Announcement{Message: message}, ""))
+
+	sent := 0
+	s.clients.Range(func(key string, peer Peer) bool {
+		if broadcaster.Broadcast(peer) == nil {
+			sent++
+		}
+		return true
+	})
+	return sent
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"github.com/CDavidSV/online-flip-flop/ai"
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+//go:generate go run ../cmd/protocolgen -o ../../protocol/schema.json
+
+type Direction string
+
+const (
+	DirectionIncoming Direction = "incoming" // Sent by clients to the server
+	DirectionOutgoing Direction = "outgoing" // Sent by the server to clients
+)
+
+// Describes a message of the websocket protocol.
+type MessageSpec struct {
+	Type        MsgType
+	Direction   Direction
+	Payload     any // Zero value of the payload, nil if the message has none
+	Description string
+}
+
+// Every message of the websocket protocol. The protocol schema is generated from this list,
+// so new message types and payloads must be added here.
+var ProtocolMessages = []MessageSpec{
+	{MsgTypeCreateRoom, DirectionIncoming, CreateRoom{}, "Create a new game room"},
+	{MsgTypeJoinRoom, DirectionIncoming, JoinRoom{}, "Join an existing game room, as a spectator if it is full"},
+	{MsgTypeLeaveRoom, DirectionIncoming, nil, "Leave a game room"},
+	{MsgTypeMove, DirectionIncoming, games.BaseMove{}, "Make a move in the game"},
+	{MsgTypeForfeit, DirectionIncoming, nil, "Forfeit the game"},
+	{MsgTypeAbort, DirectionIncoming, nil, "Abort the game before making the first move"},
+	{MsgTypeGameState, DirectionIncoming, nil, "Request the current state of the game"},
+	{MsgTypeSendMessage, DirectionIncoming, ChatMessage{}, "Send a chat message to the room"},
+	{MsgTypeRematch, DirectionIncoming, nil, "Request a rematch"},
+	{MsgTypeCancelRematch, DirectionIncoming, nil, "Cancel a rematch request"},
+	{MsgTypeClaimAbandon, DirectionIncoming, ClaimAbandon{}, "Claim a win or draw after the opponent abandoned the game"},
+	{MsgTypeResync, DirectionIncoming, Resync{}, "Request the room events missed since the given sequence number"},
+
+	{MsgTypeConnected, DirectionOutgoing, Connected{}, "Sent when a client successfully connects"},
+	{MsgTypeRoomCreated, DirectionOutgoing, RoomCreated{}, "Response after creating a room"},
+	{MsgTypeJoinedRoom, DirectionOutgoing, RoomJoined{}, "Response after joining a room"},
+	{MsgTypeLeftRoom, DirectionOutgoing, nil, "Response after leaving a room"},
+	{MsgTypeAck, DirectionOutgoing, nil, "Acknowledgment of a request"},
+	{MsgTypeGameState, DirectionOutgoing, GameState{}, "Current state of the game"},
+	{MsgTypeGameStart, DirectionOutgoing, GameState{}, "Notification that the game has started"},
+	{MsgTypeMove, DirectionOutgoing, MoveMade{}, "Notification that a move has been made"},
+	{MsgTypeGameEnd, DirectionOutgoing, GameEnded{}, "Notification that the game has ended"},
+	{MsgTypeSeriesEnd, DirectionOutgoing, SeriesEnded{}, "Notification that a match series has been decided"},
+	{MsgPlayerLeftRoom, DirectionOutgoing, PlayerLeft{}, "Notification that a player has left the room"},
+	{MsgPlayerRejoined, DirectionOutgoing, PlayerRejoined{}, "Notification that a player has rejoined the room"},
+	{MsgTypeAbandonClaimable, DirectionOutgoing, PlayerUpdate{}, "Notification that a disconnected player's grace period has run out and the game can be claimed"},
+	{MsgTypeRematchRequested, DirectionOutgoing, PlayerUpdate{}, "Notification that a rematch has been requested"},
+	{MsgTypeRematchCancelled, DirectionOutgoing, PlayerUpdate{}, "Notification that a rematch request has been cancelled"},
+	{MsgTypeChat, DirectionOutgoing, SavedMessage{}, "New chat message"},
+	{MsgTypeKicked, DirectionOutgoing, Kicked{}, "Notification that the client has been removed from the room"},
+	{MsgTypeAnnouncement, DirectionOutgoing, Announcement{}, "Server-wide announcement from the operators"},
+	{MsgTypeResync, DirectionOutgoing, ResyncResult{}, "Room events the client missed, or the game state if they are no longer buffered"},
+	{MsgTypeError, DirectionOutgoing, apperrors.AppError{}, "Error caused by a request"},
+}
+
+// Values of the enumerated types used in payloads, listed as slices of each type.
+var ProtocolEnums = []any{
+	validGameModes,
+	[]games.GameType{games.TYPE_FLIPFLOP3x3, games.TYPE_FLIPFLOP5x5},
+	ai.Difficulties(),
+	[]Status{StatusWaiting, StatusWaitingStart, StatusOngoing, StatusEnded, StatusClosed},
+	[]ColorPolicy{ColorPolicyAlternate, ColorPolicyKeep, ColorPolicyRandom},
+	[]EndReason{EndReasonNormal, EndReasonDraw, EndReasonForfeit, EndReasonAbandoned, EndReasonAborted},
+	[]AbandonClaim{ClaimWin, ClaimDraw},
+	protocolFeatures(ProtocolVersionCurrent), // Every feature, from the versions they were introduced in
+	[]Encoding{EncodingJSON, EncodingMsgpack},
+}
@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+// Rooms a client is in, stored in the session of its connection.
+// Clients on protocol versions without FeatureMultiRoom are kept to a single room.
+type clientRooms struct {
+	mu     sync.Mutex
+	local  map[string]*GameRoom
+	remote map[string]string // Rooms of other nodes, mapped to the node that owns them
+}
+
+func newClientRooms() *clientRooms {
+	return &clientRooms{
+		local:  make(map[string]*GameRoom),
+		remote: make(map[string]string),
+	}
+}
+
+// Returns the rooms of a connection.
+func roomsOf(peer Peer) *clientRooms {
+	return mustLoad[*clientRooms](peer.Session(), "rooms")
+}
+
+func (r *clientRooms) add(room *GameRoom) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.local[room.ID] = room
+}
+
+// Records a room of another node, before the node confirms the client is in it.
+func (r *clientRooms) addRemote(roomID, node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remote[roomID] = node
+}
+
+// Replaces the rooms of a node with the ones it reported the client is in.
+func (r *clientRooms) setRemote(node string, roomIDs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	maps.DeleteFunc(r.remote, func(_, owner string) bool {
+		return owner == node
+	})
+	for _, roomID := range roomIDs {
+		r.remote[roomID] = node
+	}
+}
+
+func (r *clientRooms) remove(roomID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.local, roomID)
+	delete(r.remote, roomID)
+}
+
+// Checks if the client is in a room, on this node or another one.
+func (r *clientRooms) has(roomID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, local := r.local[roomID]
+	_, remote := r.remote[roomID]
+	return local || remote
+}
+
+// Returns the number of rooms the client is in, on every node.
+func (r *clientRooms) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.local) + len(r.remote)
+}
+
+// Returns the rooms of this node the client is in.
+func (r *clientRooms) rooms() []*GameRoom {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Collect(maps.Values(r.local))
+}
+
+// Returns the IDs of the rooms of this node the client is in, sorted.
+func (r *clientRooms) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Sorted(maps.Keys(r.local))
+}
+
+// Returns the nodes that own the remote rooms of the client.
+func (r *clientRooms) nodes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nodes := slices.Sorted(maps.Values(r.remote))
+	return slices.Compact(nodes)
+}
+
+// Returns the ID of the only room the client is in, or an empty string.
+func (r *clientRooms) single() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.local)+len(r.remote) != 1 {
+		return ""
+	}
+	for roomID := range r.local {
+		return roomID
+	}
+	for roomID := range r.remote {
+		return roomID
+	}
+	return ""
+}
+
+// Finds the room a message is about: the room with the given ID, or the only room the client is in if the ID is empty.
+// Rooms of other nodes are returned as the node that owns them, with a nil room.
+func (r *clientRooms) resolve(roomID string) (string, *GameRoom, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if roomID == "" {
+		switch len(r.local) + len(r.remote) {
+		case 0:
+			return "", nil, "", apperrors.ErrNotInGame
+		case 1:
+			for id := range r.local {
+				roomID = id
+			}
+			for id := range r.remote {
+				roomID = id
+			}
+		default:
+			return "", nil, "", apperrors.ErrRoomIDRequired
+		}
+	}
+
+	if room, ok := r.local[roomID]; ok {
+		return roomID, room, "", nil
+	}
+	if node, ok := r.remote[roomID]; ok {
+		return roomID, nil, node, nil
+	}
+	return "", nil, "", apperrors.ErrNotInGame
+}
@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+func TestRoomMessagesFindTheirRoom(t *testing.T) {
+	tests := []struct {
+		name     string
+		rooms    int // Rooms the client created before asking for the game state
+		roomID   int // Index of the room the message names, -1 for none and len(rooms) for one the client is not in
+		wantRoom int // Index of the room answered about, when there is no error
+		wantErr  error
+	}{
+		{name: "no rooms", rooms: 0, roomID: -1, wantErr: apperrors.ErrNotInGame},
+		{name: "only room", rooms: 1, roomID: -1, wantRoom: 0},
+		{name: "several rooms without ID", rooms: 2, roomID: -1, wantErr: apperrors.ErrRoomIDRequired},
+		{name: "several rooms with ID", rooms: 2, roomID: 1, wantRoom: 1},
+		{name: "room the client is not in", rooms: 2, roomID: 2, wantErr: apperrors.ErrNotInGame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			client := connectTestClient(t, srv)
+			other := connectTestClient(t, srv)
+
+			var roomIDs []string
+			for range tt.rooms {
+				roomIDs = append(roomIDs, client.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"}))
+			}
+			roomIDs = append(roomIDs, other.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "other"}))
+
+			roomID := ""
+			if tt.roomID >= 0 {
+				roomID = roomIDs[tt.roomID]
+			}
+			client.send(MsgTypeGameState, roomID, nil)
+
+			if tt.wantErr != nil {
+				client.expectError(tt.wantErr.Error())
+				return
+			}
+			if msg := client.expect(MsgTypeGameState); msg.RoomID != roomIDs[tt.wantRoom] {
+				t.Fatalf("got game state of room %s, want %s", msg.RoomID, roomIDs[tt.wantRoom])
+			}
+		})
+	}
+}
+
+func TestClientsWithoutMultiRoomStayInOneRoom(t *testing.T) {
+	srv := newTestServer(t, nil)
+	client, err := connectTestClientOn(t, srv, "2")
+	if err != nil {
+		t.Fatalf("connecting client: %v", err)
+	}
+	client.expect(MsgTypeConnected)
+
+	client.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+	client.send(MsgTypeCreateRoom, "", CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+	client.expectError(apperrors.ErrAlreadyInGame.Error())
+
+	// Room messages need no room ID, since there is only one room they can be about
+	client.send(MsgTypeGameState, "", nil)
+	client.expect(MsgTypeGameState)
+}
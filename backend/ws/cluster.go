@@ -0,0 +1,234 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/CDavidSV/online-flip-flop/cluster"
+	"github.com/lxzan/gws"
+)
+
+type clusterMsgKind string
+
+const (
+	clusterReceive    clusterMsgKind = "receive"    // Message of a client, forwarded to the node that owns its room
+	clusterDisconnect clusterMsgKind = "disconnect" // The client of a forwarded connection disconnected
+	clusterDeliver    clusterMsgKind = "deliver"    // Message for a client, sent back to the node it is connected to
+	clusterClose      clusterMsgKind = "close"      // The owner of the room closed the connection of a client
+	clusterRooms      clusterMsgKind = "rooms"      // Rooms of the owner the client is in, its messages about other rooms are no longer forwarded
+
+	clusterQueryRoom   clusterMsgKind = "query"       // Asks another node about itself or a room it owns, for the REST API
+	clusterReply       clusterMsgKind = "reply"       // Answer to a query
+	clusterFeedEvent   clusterMsgKind = "feed_event"  // Event of a spectator feed followed through another node
+	clusterFeedEnd     clusterMsgKind = "feed_end"    // The owner stopped relaying a spectator feed
+	clusterUnsubscribe clusterMsgKind = "unsubscribe" // The node that asked for a spectator feed no longer follows it
+)
+
+// Message exchanged between nodes about a client connected to one node and playing in a room of another,
+// or about a room of one node that is looked at through another.
+type clusterMessage struct {
+	Kind     clusterMsgKind  `json:"kind"`
+	Node     string          `json:"node"` // Node that sent the message
+	ConnID   string          `json:"conn_id"`
+	ClientID string          `json:"client_id"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Code     uint16          `json:"code,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	Rooms    []string        `json:"rooms,omitempty"`
+	MsgType  MsgType         `json:"msg_type,omitempty"` // Type of a delivered message, for the slow consumer policy of the client
+
+	// Set on queries and the messages about feeds
+	ReplyTo     string       `json:"reply_to,omitempty"` // ID of the query, or of the feed subscription
+	RoomID      string       `json:"room_id,omitempty"`
+	Query       clusterQuery `json:"query,omitempty"`
+	LastEventID *uint64      `json:"last_event_id,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Makes the server a node of a cluster, sharing rooms with other nodes through the directory and the bus.
+// An empty nodeID keeps the one generated for the server. Must be called before Start.
+func (s *Server) UseCluster(nodeID string, directory cluster.Directory, bus cluster.Bus) {
+	if nodeID != "" {
+		s.nodeID = nodeID
+	}
+	s.directory = directory
+	s.bus = bus
+}
+
+// Returns the ID of the server in the cluster.
+func (s *Server) NodeID() string {
+	return s.nodeID
+}
+
+// Sends a message to another node.
+func (s *Server) publish(node string, msg clusterMessage) error {
+	msg.Node = s.nodeID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.bus.Publish(cluster.NodeTopic(node), data)
+}
+
+// Forwards a message of a client to the node that owns the room the message is about.
+func (s *Server) forward(peer Peer, node string, msg IncomingMessage) error {
+	data, err := json.Marshal(msg)
+	if err == nil {
+		err = s.publish(node, clusterMessage{
+			Kind:     clusterReceive,
+			ConnID:   mustLoad[string](peer.Session(), "conn_id"),
+			ClientID: mustLoad[string](peer.Session(), "client_id"),
+			Data:     data,
+		})
+	}
+	if err != nil {
+		s.requestLogger(peer, msg.RequestID).Error("Failed to forward message", "node", node, "error", err)
+		s.writeError(peer, err, msg.RequestID)
+		return err
+	}
+
+	// The node that owns the room answers the request and its retries
+	forgetRequest(peer, msg.RequestID)
+	return nil
+}
+
+// Handles a message from another node.
+func (s *Server) handleClusterMessage(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		s.logger.Error("Received invalid cluster message", "error", err)
+		return
+	}
+
+	switch msg.Kind {
+	case clusterReceive:
+		s.receiveForwarded(msg)
+	case clusterDisconnect:
+		if peer, ok := s.remotes.Load(msg.ConnID); ok {
+			s.remotes.Delete(msg.ConnID)
+			s.leaveAllRooms(peer)
+		}
+	case clusterQueryRoom:
+		s.answerQuery(msg)
+	case clusterReply, clusterFeedEvent, clusterFeedEnd, clusterUnsubscribe:
+		s.handleQueryMessage(msg)
+	case clusterDeliver, clusterClose, clusterRooms:
+		peer, ok := s.clients.Load(msg.ClientID)
+		if !ok || mustLoad[string](peer.Session(), "conn_id") != msg.ConnID {
+			return
+		}
+
+		switch msg.Kind {
+		case clusterDeliver:
+			if typed, ok := peer.(typedSender); ok {
+				typed.sendTyped(msg.MsgType, msg.Data, nil)
+			} else {
+				peer.SendAsync(msg.Data, nil)
+			}
+		case clusterClose:
+			peer.Close(msg.Code, msg.Reason)
+		case clusterRooms:
+			roomsOf(peer).setRemote(msg.Node, msg.Rooms)
+		}
+	}
+}
+
+// Handles a message of a client connected to another node, as if the client was connected here.
+func (s *Server) receiveForwarded(msg clusterMessage) {
+	peer, ok := s.remotes.Load(msg.ConnID)
+	if !ok {
+		peer = newRemotePeer(s, msg)
+		s.remotes.Store(msg.ConnID, peer)
+	}
+
+	s.Receive(peer, msg.Data)
+	s.syncRemoteRooms(peer)
+}
+
+// Tells the node a client is connected to which rooms of this node the client is in,
+// so that it only forwards messages about those rooms. The peer is dropped once it is in none.
+func (s *Server) syncRemoteRooms(peer *remotePeer) {
+	roomIDs := roomsOf(peer).ids()
+	if len(roomIDs) == 0 {
+		s.remotes.Delete(peer.connID)
+	}
+
+	err := s.publish(peer.node, clusterMessage{
+		Kind:     clusterRooms,
+		ConnID:   peer.connID,
+		ClientID: peer.clientID,
+		Rooms:    roomIDs,
+	})
+	if err != nil {
+		s.connLogger(peer).Error("Failed to send rooms of forwarded client", "node", peer.node, "error", err)
+	}
+}
+
+// A client connected to another node and playing in a room of this one.
+// Messages sent to it are relayed by the node it is connected to.
+type remotePeer struct {
+	server   *Server
+	session  gws.SessionStorage
+	node     string
+	connID   string
+	clientID string
+}
+
+func newRemotePeer(s *Server, msg clusterMessage) *remotePeer {
+	p := &remotePeer{
+		server:   s,
+		session:  gws.NewConcurrentMap[string, any](),
+		node:     msg.Node,
+		connID:   msg.ConnID,
+		clientID: msg.ClientID,
+	}
+
+	// The node the client is connected to adapts messages to its protocol version
+	p.session.Store("protocol_version", ProtocolVersionCurrent)
+	p.session.Store("client_id", msg.ClientID)
+	p.session.Store("conn_id", msg.ConnID)
+	p.session.Store("logger", s.logger.With("conn_id", msg.ConnID, "client_id", msg.ClientID, "node", msg.Node))
+	p.session.Store("requests", s.requestCacheFor(msg.ClientID))
+	p.session.Store("rooms", newClientRooms())
+	return p
+}
+
+func (p *remotePeer) Session() gws.SessionStorage {
+	return p.session
+}
+
+// Queues the message on the bus. It is written by the node the client is connected to.
+func (p *remotePeer) Send(msg []byte) error {
+	return p.deliver("", msg)
+}
+
+func (p *remotePeer) SendAsync(msg []byte, callback func(error)) {
+	p.sendTyped("", msg, callback)
+}
+
+func (p *remotePeer) sendTyped(msgType MsgType, msg []byte, callback func(error)) {
+	err := p.deliver(msgType, msg)
+	if callback != nil {
+		callback(err)
+	}
+}
+
+// Publishes a message for the client to the node it is connected to.
+func (p *remotePeer) deliver(msgType MsgType, msg []byte) error {
+	return p.server.publish(p.node, clusterMessage{
+		Kind:     clusterDeliver,
+		ConnID:   p.connID,
+		ClientID: p.clientID,
+		Data:     msg,
+		MsgType:  msgType,
+	})
+}
+
+func (p *remotePeer) Close(code uint16, reason string) error {
+	return p.server.publish(p.node, clusterMessage{
+		Kind:     clusterClose,
+		ConnID:   p.connID,
+		ClientID: p.clientID,
+		Code:     code,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,301 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/cluster"
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+// Returns two started servers sharing a memory directory and bus, as two nodes of the same cluster.
+func newTestCluster(t *testing.T) (*Server, *Server, *cluster.MemoryDirectory) {
+	t.Helper()
+
+	directory := cluster.NewMemoryDirectory()
+	bus := cluster.NewMemoryBus()
+	nodes := make([]*Server, 2)
+	for i, nodeID := range []string{"node-a", "node-b"} {
+		nodes[i] = newUnstartedTestServer(nil)
+		nodes[i].UseCluster(nodeID, directory, bus)
+		startTestServer(t, nodes[i])
+	}
+	return nodes[0], nodes[1], directory
+}
+
+func TestRoomTakenByAnotherNodeIsClosed(t *testing.T) {
+	a, _, directory := newTestCluster(t)
+	host := connectTestClient(t, a)
+	roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+
+	// The claim of node A lapsed and node B claimed the room before A renewed it
+	directory.Release(context.Background(), roomID, a.NodeID())
+	if err := directory.Claim(context.Background(), roomID, "node-b"); err != nil {
+		t.Fatalf("claiming room for node B: %v", err)
+	}
+	a.renewRoomClaim(a.GetGameRoom(roomID))
+
+	var kicked Kicked
+	host.expectPayload(MsgTypeKicked, &kicked)
+	if kicked.Reason != "room_taken" {
+		t.Fatalf("got reason %q, want %q", kicked.Reason, "room_taken")
+	}
+	if a.GetGameRoom(roomID) != nil {
+		t.Fatal("room is still open on node A")
+	}
+	if owner, _ := directory.Owner(context.Background(), roomID); owner != "node-b" {
+		t.Fatalf("room is owned by %q, want node-b", owner)
+	}
+}
+
+// Directory that remembers the rooms claimed through it.
+type recordingDirectory struct {
+	cluster.Directory
+	mu      sync.Mutex
+	claimed []string
+}
+
+func (d *recordingDirectory) Claim(ctx context.Context, roomID, nodeID string) error {
+	d.mu.Lock()
+	d.claimed = append(d.claimed, roomID)
+	d.mu.Unlock()
+	return d.Directory.Claim(ctx, roomID, nodeID)
+}
+
+func TestFailedRoomCreationReleasesClaim(t *testing.T) {
+	directory := &recordingDirectory{Directory: cluster.NewMemoryDirectory()}
+	srv := newUnstartedTestServer(nil)
+	srv.UseCluster("node-a", directory, cluster.NewMemoryBus())
+	startTestServer(t, srv)
+
+	// Passes validation, but the room cannot set up a game of this type
+	client := connectTestClient(t, srv)
+	client.send(MsgTypeCreateRoom, "", CreateRoom{GameType: "chess", GameMode: "multiplayer", Username: "host"})
+	client.expect(MsgTypeError)
+
+	directory.mu.Lock()
+	defer directory.mu.Unlock()
+	if len(directory.claimed) == 0 {
+		t.Fatal("no room ID was claimed")
+	}
+	for _, roomID := range directory.claimed {
+		if owner, err := directory.Owner(context.Background(), roomID); err == nil {
+			t.Fatalf("room %s is still claimed by %s", roomID, owner)
+		}
+	}
+}
+
+// Waits until cond holds, failing the test if it does not before the test timeout.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Starts a game in a room of node A between a host connected to A and a guest connected to B.
+func startClusterGame(t *testing.T, a, b *Server) (host, guest *testClient, roomID string, state GameState) {
+	t.Helper()
+
+	host = connectTestClient(t, a)
+	guest = connectTestClient(t, b)
+	roomID = host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+
+	guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+	guest.expect(MsgTypeJoinedRoom)
+	host.expectPayload(MsgTypeGameStart, &state)
+	return host, guest, roomID, state
+}
+
+func TestClusterRelaysGameBetweenNodes(t *testing.T) {
+	a, b, _ := newTestCluster(t)
+	host, guest, roomID, state := startClusterGame(t, a, b)
+
+	if a.GetGameRoom(roomID) == nil || b.GetGameRoom(roomID) != nil {
+		t.Fatal("room should only be held by node A")
+	}
+
+	// Each player moves once, so moves are relayed both from and to the node of the guest
+	mirror := games.NewFlipFlopGame(games.FlipFlop3x3)
+	players := map[games.PlayerSide]*testClient{
+		colorOf(t, state, host.id):  host,
+		colorOf(t, state, guest.id): guest,
+	}
+	for range 2 {
+		mover := players[mirror.CurrentTurn()]
+		opponent := host
+		if mover == host {
+			opponent = guest
+		}
+
+		move := firstValidMove(t, mirror)
+		applyMove(t, mirror, move)
+		requestID := mover.send(MsgTypeMove, roomID, move)
+		if ack := mover.expect(MsgTypeAck); ack.RequestID != requestID {
+			t.Fatalf("got ack for request %q, want %q", ack.RequestID, requestID)
+		}
+
+		var made MoveMade
+		opponent.expectPayload(MsgTypeMove, &made)
+		if made.PlayerID != mover.id || made.Board != mirror.GetBoardString() {
+			t.Fatalf("got move of %s with board %s, want move of %s with board %s", made.PlayerID, made.Board, mover.id, mirror.GetBoardString())
+		}
+	}
+}
+
+func TestClusterSyncsRoomsOfForwardedClient(t *testing.T) {
+	a, b, _ := newTestCluster(t)
+	_, guest, roomID, _ := startClusterGame(t, a, b)
+
+	eventually(t, "node A tracks the guest", func() bool { return a.remotes.Len() == 1 })
+	if !roomsOf(guest.peer).has(roomID) {
+		t.Fatal("node B does not know the guest is in the room")
+	}
+
+	// Once the owner reports the guest left, its messages about the room are no longer forwarded
+	guest.send(MsgTypeLeaveRoom, roomID, nil)
+	eventually(t, "node B forgets the room", func() bool { return !roomsOf(guest.peer).has(roomID) })
+	eventually(t, "node A drops the guest", func() bool { return a.remotes.Len() == 0 })
+
+	guest.send(MsgTypeSendMessage, roomID, ChatMessage{Content: "hello"})
+	guest.expectError(apperrors.ErrNotInGame.Error())
+}
+
+func TestClusterDisconnectLeavesRemoteRoom(t *testing.T) {
+	a, b, _ := newTestCluster(t)
+	host, guest, _, _ := startClusterGame(t, a, b)
+
+	b.Disconnect(guest.peer, nil)
+
+	var left PlayerLeft
+	host.expectPayload(MsgPlayerLeftRoom, &left)
+	if left.PlayerID != guest.id {
+		t.Fatalf("got player %s leaving, want %s", left.PlayerID, guest.id)
+	}
+	eventually(t, "node A drops the guest", func() bool { return a.remotes.Len() == 0 })
+}
+
+func TestClusterAnswersRoomQueriesOfOtherNodes(t *testing.T) {
+	a, b, _ := newTestCluster(t)
+	host, guest, roomID, state := startClusterGame(t, a, b)
+	ctx := context.Background()
+
+	room, err := b.FindRoom(ctx, roomID)
+	if err != nil {
+		t.Fatalf("finding room through node B: %v", err)
+	}
+	if room.ID != roomID || room.Status != StatusOngoing || len(room.Players) != 2 {
+		t.Fatalf("got room %s in status %q with %d players", room.ID, room.Status, len(room.Players))
+	}
+
+	if _, err := b.FindGameRecord(ctx, roomID); !errors.Is(err, apperrors.ErrGameNotEnded) {
+		t.Fatalf("got error %v for the record of a running game, want %v", err, apperrors.ErrGameNotEnded)
+	}
+	if _, err := b.FindRoom(ctx, "missing"); !errors.Is(err, apperrors.ErrRoomNotFound) {
+		t.Fatalf("got error %v for a missing room, want %v", err, apperrors.ErrRoomNotFound)
+	}
+
+	sub, unsubscribe, err := b.SubscribeRoomFeed(ctx, roomID, nil)
+	if err != nil {
+		t.Fatalf("following feed through node B: %v", err)
+	}
+	if len(sub.Backlog) != 1 || sub.Backlog[0].Type != MsgTypeGameState {
+		t.Fatalf("got backlog %+v, want the game state", sub.Backlog)
+	}
+
+	mover := host
+	if colorOf(t, state, guest.id) == games.COLOR_WHITE {
+		mover = guest
+	}
+	mover.send(MsgTypeMove, roomID, firstValidMove(t, games.NewFlipFlopGame(games.FlipFlop3x3)))
+
+	select {
+	case event := <-sub.Events:
+		var msg testMessage
+		json.Unmarshal(event.Data, &msg)
+		if event.Type != MsgTypeMove || msg.RoomID != roomID {
+			t.Fatalf("got %s event for room %q, want a move in %q", event.Type, msg.RoomID, roomID)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the move on the relayed feed")
+	}
+
+	unsubscribe()
+	eventually(t, "node A stops relaying the feed", func() bool { return a.servedFeeds.Len() == 0 })
+}
+
+func TestClusterAdminActionsReachOtherNodes(t *testing.T) {
+	a, b, _ := newTestCluster(t)
+	_, guest, roomID, _ := startClusterGame(t, a, b)
+	other := connectTestClient(t, b)
+	otherRoomID := other.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "other"})
+	ctx := context.Background()
+
+	// Both nodes list the rooms of the whole cluster
+	for node, srv := range map[string]*Server{"A": a, "B": b} {
+		listed := make(map[string]bool)
+		for _, room := range srv.ListRooms(ctx) {
+			listed[room.ID] = true
+		}
+		for _, room := range srv.ListRoomDetails(ctx) {
+			listed[room.ID] = listed[room.ID] && len(room.Clients) > 0
+		}
+		if len(listed) != 2 || !listed[roomID] || !listed[otherRoomID] {
+			t.Fatalf("node %s lists rooms %v, want %s and %s with their clients", node, listed, roomID, otherRoomID)
+		}
+	}
+
+	if sent := a.Announce(ctx, "restarting soon"); sent != 3 {
+		t.Fatalf("announcement sent to %d clients, want 3", sent)
+	}
+	guest.expect(MsgTypeAnnouncement)
+
+	details, err := b.FindRoomDetails(ctx, roomID)
+	if err != nil {
+		t.Fatalf("finding room details through node B: %v", err)
+	}
+	if details.ID != roomID || len(details.Clients) != 2 {
+		t.Fatalf("got details of room %s with %d clients", details.ID, len(details.Clients))
+	}
+
+	// The room of node A is closed through node B
+	if err := b.CloseRoom(ctx, roomID, "maintenance"); err != nil {
+		t.Fatalf("closing room through node B: %v", err)
+	}
+	var kicked Kicked
+	guest.expectPayload(MsgTypeKicked, &kicked)
+	if kicked.Reason != "maintenance" {
+		t.Fatalf("got reason %q, want %q", kicked.Reason, "maintenance")
+	}
+	if a.GetGameRoom(roomID) != nil {
+		t.Fatal("room is still open on node A")
+	}
+	if err := b.CloseRoom(ctx, roomID, "maintenance"); !errors.Is(err, apperrors.ErrRoomNotFound) {
+		t.Fatalf("got error %v closing a closed room, want %v", err, apperrors.ErrRoomNotFound)
+	}
+	if events, err := b.FindRoomEvents(ctx, roomID); err != nil || len(events) == 0 {
+		t.Fatalf("got %d events and error %v for the log archived by node A", len(events), err)
+	}
+
+	// A client connected to node B is kicked through node A
+	if err := a.KickClient(ctx, other.id, "spam"); err != nil {
+		t.Fatalf("kicking client through node A: %v", err)
+	}
+	other.expectPayload(MsgTypeKicked, &kicked)
+	if kicked.Reason != "spam" {
+		t.Fatalf("got reason %q, want %q", kicked.Reason, "spam")
+	}
+	if err := a.KickClient(ctx, "missing", "spam"); !errors.Is(err, apperrors.ErrClientNotFound) {
+		t.Fatalf("got error %v kicking a missing client, want %v", err, apperrors.ErrClientNotFound)
+	}
+}
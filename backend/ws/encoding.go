@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Encoding string
+
+const (
+	EncodingJSON    Encoding = "json"    // Messages are JSON text frames
+	EncodingMsgpack Encoding = "msgpack" // Messages are MessagePack binary frames, with the same shape as the JSON messages
+)
+
+// Parses the message encoding requested by a client. JSON is used if none is requested.
+func ParseEncoding(value string) (Encoding, error) {
+	switch Encoding(value) {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingMsgpack:
+		return EncodingMsgpack, nil
+	}
+	return "", apperrors.ErrUnsupportedEncoding
+}
+
+// Converts an encoded JSON message to MessagePack.
+// Messages are built as JSON, so clients on MessagePack get the same fields and values.
+func jsonToMsgpack(msg []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.UseCompactInts(true)
+	encoder.UseCompactFloats(true)
+	if err := encoder.Encode(fromJSONNumbers(value)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Converts a MessagePack message from a client to JSON.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var value any
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// Replaces JSON numbers with integers where possible, so they are encoded compactly.
+func fromJSONNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		n, _ := v.Float64()
+		return n
+	case map[string]any:
+		for key, item := range v {
+			v[key] = fromJSONNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = fromJSONNumbers(item)
+		}
+	}
+	return value
+}
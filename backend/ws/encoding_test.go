@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/google/uuid"
+	"github.com/lxzan/gws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Waits for the next message, checks it came in a binary frame and decodes it as MessagePack.
+func (s *testSocket) nextMsgpack() map[string]any {
+	s.t.Helper()
+
+	frame := s.nextFrame()
+	if frame.opcode != gws.OpcodeBinary {
+		s.t.Fatalf("got message %s in a frame with opcode %d, want a binary frame", frame.data, frame.opcode)
+	}
+	var msg map[string]any
+	if err := msgpack.Unmarshal(frame.data, &msg); err != nil {
+		s.t.Fatalf("decoding MessagePack message: %v", err)
+	}
+	return msg
+}
+
+// Sends a message to the server in a MessagePack binary frame.
+func (s *testSocket) sendMsgpack(msgType MsgType, requestID string, payload any) {
+	s.t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		s.t.Fatalf("encoding %s payload: %v", msgType, err)
+	}
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+
+	msg, err := msgpack.Marshal(map[string]any{"type": msgType, "request_id": requestID, "payload": fields})
+	if err != nil {
+		s.t.Fatalf("encoding %s message: %v", msgType, err)
+	}
+	if err := s.conn.WriteMessage(gws.OpcodeBinary, msg); err != nil {
+		s.t.Fatalf("sending %s message: %v", msgType, err)
+	}
+}
+
+func TestMsgpackClientsGetBinaryFrames(t *testing.T) {
+	srv := newTestServer(t, nil)
+	socket := dialTestServer(t, srv, "protocol=3&encoding=msgpack")
+
+	connected := socket.nextMsgpack()
+	payload, _ := connected["payload"].(map[string]any)
+	protocol, _ := payload["protocol"].(map[string]any)
+	if connected["type"] != string(MsgTypeConnected) || protocol["encoding"] != string(EncodingMsgpack) {
+		t.Fatalf("got %v, want %s with encoding %s", connected, MsgTypeConnected, EncodingMsgpack)
+	}
+	// Numbers are sent as integers, not as the floats JSON would decode them to
+	if version, ok := protocol["version"].(int8); !ok || int(version) != ProtocolVersionCurrent {
+		t.Fatalf("got protocol version %v (%T), want integer %d", protocol["version"], protocol["version"], ProtocolVersionCurrent)
+	}
+
+	requestID := uuid.NewString()
+	socket.sendMsgpack(MsgTypeCreateRoom, requestID, CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+	created := socket.nextMsgpack()
+	payload, _ = created["payload"].(map[string]any)
+	if roomID, _ := payload["room_id"].(string); created["type"] != string(MsgTypeRoomCreated) || created["request_id"] != requestID || roomID == "" {
+		t.Fatalf("got %v, want %s for request %s", created, MsgTypeRoomCreated, requestID)
+	}
+
+	// Binary frames that are not MessagePack are reported like malformed JSON
+	if err := socket.conn.WriteMessage(gws.OpcodeBinary, []byte{0xc1}); err != nil {
+		t.Fatalf("sending invalid message: %v", err)
+	}
+	invalid := socket.nextMsgpack()
+	payload, _ = invalid["payload"].(map[string]any)
+	if invalid["type"] != string(MsgTypeError) || payload["code"] != apperrors.ErrInvalidMessageFormat.Error() {
+		t.Fatalf("got %v, want %s %s", invalid, MsgTypeError, apperrors.ErrInvalidMessageFormat)
+	}
+}
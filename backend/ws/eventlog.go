@@ -0,0 +1,365 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/ai"
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+type RoomEventType string
+
+const (
+	EventRoomCreated      RoomEventType = "room_created"      // The room was created by its first player.
+	EventPlayerJoined     RoomEventType = "player_joined"     // A new player took a player slot.
+	EventSpectatorJoined  RoomEventType = "spectator_joined"  // A client joined as a spectator.
+	EventPlayerRejoined   RoomEventType = "player_rejoined"   // A player reconnected to their slot.
+	EventLeft             RoomEventType = "left"              // A player or spectator left the room.
+	EventGameStarted      RoomEventType = "game_started"      // A game started, either the first one or a rematch.
+	EventMove             RoomEventType = "move"              // A player made a move.
+	EventChat             RoomEventType = "chat"              // A chat message was sent.
+	EventRematchRequested RoomEventType = "rematch_requested" // A player requested a rematch.
+	EventRematchCancelled RoomEventType = "rematch_cancelled" // A player cancelled their rematch request.
+	EventForfeit          RoomEventType = "forfeit"           // A player forfeited the game.
+	EventAbort            RoomEventType = "abort"             // A player aborted the game.
+	EventAbandonClaimed   RoomEventType = "abandon_claimed"   // A player claimed a game abandoned by the opponent.
+	EventGameEnded        RoomEventType = "game_ended"        // The game ended, for any reason.
+	EventRoomClosed       RoomEventType = "room_closed"       // The room was closed.
+)
+
+// A single entry in the append-only event log of a room.
+type RoomEvent struct {
+	Seq      int             `json:"seq"`
+	Type     RoomEventType   `json:"type"`
+	Time     time.Time       `json:"time"`
+	ClientID string          `json:"client_id,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+type roomCreatedEvent struct {
+	RoomID         string          `json:"room_id"`
+	GameMode       GameMode        `json:"game_mode"`
+	GameType       games.GameType  `json:"game_type"`
+	AIDifficulty   ai.AIDifficulty `json:"ai_difficulty,omitempty"`
+	SpectatorDelay SpectatorDelay  `json:"spectator_delay"`
+	SeriesLength   int             `json:"series_length,omitempty"`
+	ColorPolicy    ColorPolicy     `json:"color_policy"`
+	AbandonGrace   time.Duration   `json:"abandon_grace,omitempty"` // Set if the room overrides the server setting
+	Players        []PlayerSlot    `json:"players"`
+}
+
+type playerJoinedEvent struct {
+	Username string           `json:"username"`
+	Color    games.PlayerSide `json:"color"`
+}
+
+type spectatorJoinedEvent struct {
+	Username string `json:"username"`
+}
+
+type gameStartedEvent struct {
+	Colors map[string]games.PlayerSide `json:"colors"`
+}
+
+type moveEvent struct {
+	Color games.PlayerSide `json:"color"`
+	Move  json.RawMessage  `json:"move"`
+}
+
+type chatEvent struct {
+	Message   string `json:"message"`
+	Spectator bool   `json:"spectator"`
+}
+
+type abandonClaimedEvent struct {
+	Result AbandonClaim `json:"result"`
+}
+
+type gameEndedEvent struct {
+	Reason EndReason        `json:"reason"`
+	Winner games.PlayerSide `json:"winner"`
+}
+
+type roomClosedEvent struct {
+	Reason string `json:"reason"`
+}
+
+// Appends an event to the room's log.
+// Must be called from the room goroutine.
+func (gr *GameRoom) recordEvent(eventType RoomEventType, clientID string, data any) {
+	var raw json.RawMessage
+	if data != nil {
+		raw, _ = json.Marshal(data)
+	}
+
+	gr.events = append(gr.events, RoomEvent{
+		Seq:      len(gr.events) + 1,
+		Type:     eventType,
+		Time:     time.Now(),
+		ClientID: clientID,
+		Data:     raw,
+	})
+}
+
+// Records the start of a game along with the colors each player has.
+// Must be called from the room goroutine.
+func (gr *GameRoom) recordGameStarted() {
+	gr.recordEvent(EventGameStarted, "", gameStartedEvent{
+		Colors: map[string]games.PlayerSide{
+			gr.player1.ID: gr.player1.Color,
+			gr.player2.ID: gr.player2.Color,
+		},
+	})
+}
+
+// Returns a copy of the room's event log.
+func (gr *GameRoom) GetEvents() (events []RoomEvent) {
+	gr.do(func() { events = slices.Clone(gr.events) })
+	return events
+}
+
+// Rebuilds a room by replaying its event log.
+// The replayed room has no connections, AI or timers, it only reflects the state recorded in the log.
+func ReplayRoom(events []RoomEvent, logger *slog.Logger) (*GameRoom, error) {
+	if len(events) == 0 || events[0].Type != EventRoomCreated {
+		return nil, apperrors.ErrInvalidEventLog
+	}
+
+	var created roomCreatedEvent
+	if err := json.Unmarshal(events[0].Data, &created); err != nil || len(created.Players) == 0 {
+		return nil, apperrors.ErrInvalidEventLog
+	}
+
+	game, err := games.NewGame(created.GameType)
+	if err != nil {
+		return nil, err
+	}
+
+	gr := &GameRoom{
+		ID:                created.RoomID,
+		Game:              game,
+		GameMode:          created.GameMode,
+		GameType:          created.GameType,
+		conns:             make(map[string]*ClientConnection),
+		playerFeed:        newRoomFeed(created.RoomID),
+		spectatorFeed:     newRoomFeed(created.RoomID),
+		status:            StatusWaiting,
+		logger:            logger,
+		playerMessages:    []SavedMessage{},
+		spectatorMessages: []SavedMessage{},
+		lastInactiveTime:  events[0].Time,
+		spectatorDelay:    created.SpectatorDelay,
+		series:            newSeries(created.SeriesLength),
+		seriesLength:      created.SeriesLength,
+		colorPolicy:       created.ColorPolicy,
+		abandonGrace:      created.AbandonGrace,
+	}
+	gr.spectatorFeed.view = gr.publicPayload
+
+	player1 := created.Players[0]
+	gr.player1 = &player1
+	if len(created.Players) > 1 {
+		player2 := created.Players[1]
+		gr.player2 = &player2
+		gr.status = StatusWaitingStart
+	}
+
+	// Keeps track of the username of every client for chat history
+	usernames := map[string]string{}
+	for _, player := range created.Players {
+		usernames[player.ID] = player.Username
+	}
+
+	gr.events = slices.Clone(events[:1])
+	for _, event := range events[1:] {
+		if err := gr.replayEvent(event, usernames); err != nil {
+			return nil, err
+		}
+		gr.events = append(gr.events, event)
+	}
+	gr.spectatorView = gr.currentSpectatorView()
+	gr.startStopped()
+
+	return gr, nil
+}
+
+// Applies a single logged event to the room's state.
+func (gr *GameRoom) replayEvent(event RoomEvent, usernames map[string]string) error {
+	player := gr.getPlayer(event.ClientID)
+
+	switch event.Type {
+	case EventPlayerJoined:
+		var data playerJoinedEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		usernames[event.ClientID] = data.Username
+
+		slot := &PlayerSlot{
+			ID:       event.ClientID,
+			Username: data.Username,
+			Color:    data.Color,
+			IsActive: true,
+		}
+		if gr.player1 == nil {
+			gr.player1 = slot
+		} else {
+			gr.player2 = slot
+		}
+		if gr.playersActive() {
+			gr.status = StatusWaitingStart
+		}
+	case EventSpectatorJoined:
+		var data spectatorJoinedEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		usernames[event.ClientID] = data.Username
+	case EventPlayerRejoined:
+		if player == nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		player.IsActive = true
+		switch {
+		case gr.status == StatusEnded:
+		case gr.playersActive() && gr.gameStarted:
+			gr.status = StatusOngoing
+		default:
+			gr.status = StatusWaitingStart
+		}
+	case EventLeft:
+		if player == nil {
+			return nil
+		}
+		player.IsActive = false
+		player.wantsRematch = false
+		if gr.status != StatusEnded && gr.status != StatusClosed {
+			gr.status = StatusWaiting
+		}
+		if gr.playersInactive() {
+			gr.lastInactiveTime = event.Time
+		}
+	case EventGameStarted:
+		var data gameStartedEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil || gr.player1 == nil || gr.player2 == nil {
+			return apperrors.ErrInvalidEventLog
+		}
+
+		// Any game after the first one is a rematch
+		if gr.gameStarted {
+			game, err := games.NewGame(gr.GameType)
+			if err != nil {
+				return err
+			}
+			gr.Game = game
+
+			if gr.series != nil {
+				if gr.series.Ended {
+					gr.series = newSeries(gr.seriesLength)
+				} else {
+					gr.series.nextGame()
+				}
+			}
+		}
+
+		gr.player1.Color = data.Colors[gr.player1.ID]
+		gr.player2.Color = data.Colors[gr.player2.ID]
+		gr.player1.wantsRematch = false
+		gr.player2.wantsRematch = false
+		gr.result = nil
+		gr.gameStarted = true
+		gr.status = StatusOngoing
+	case EventMove:
+		var data moveEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		if err := gr.Game.ApplyMove(data.Move); err != nil {
+			return err
+		}
+	case EventChat:
+		var data chatEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		gr.saveChatMessage(SavedMessage{
+			ClientID: event.ClientID,
+			Username: usernames[event.ClientID],
+			Message:  data.Message,
+		}, data.Spectator)
+	case EventRematchRequested, EventRematchCancelled:
+		if player != nil {
+			player.wantsRematch = event.Type == EventRematchRequested
+		}
+	case EventGameEnded:
+		var data gameEndedEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return apperrors.ErrInvalidEventLog
+		}
+		gr.status = StatusEnded
+		gr.result = &GameResult{Reason: data.Reason, Winner: data.Winner}
+		if gr.series != nil && !gr.series.Ended && data.Reason != EndReasonAborted {
+			gr.series.recordResult(gr.player1, gr.player2, data.Winner)
+		}
+	case EventRoomClosed:
+		gr.status = StatusClosed
+	case EventForfeit, EventAbort, EventAbandonClaimed:
+		// The outcome is recorded by the game_ended event that follows
+	default:
+		return apperrors.ErrInvalidEventLog
+	}
+
+	return nil
+}
+
+// Keeps the event logs of the most recently closed rooms.
+type roomLogArchive struct {
+	mu       sync.Mutex
+	logs     map[string][]RoomEvent
+	order    []string
+	capacity int
+}
+
+func newRoomLogArchive(capacity int) *roomLogArchive {
+	return &roomLogArchive{
+		logs:     make(map[string][]RoomEvent),
+		capacity: capacity,
+	}
+}
+
+// Stores the log of a closed room, evicting the oldest log if the archive is full.
+func (a *roomLogArchive) store(roomID string, events []RoomEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.capacity <= 0 {
+		return
+	}
+
+	// Room IDs can be reused, the newest log replaces the old one
+	if _, exists := a.logs[roomID]; exists {
+		a.order = slices.DeleteFunc(a.order, func(id string) bool { return id == roomID })
+	}
+
+	if len(a.order) >= a.capacity {
+		delete(a.logs, a.order[0])
+		a.order = a.order[1:]
+	}
+
+	a.logs[roomID] = events
+	a.order = append(a.order, roomID)
+}
+
+// Returns the archived log of a room.
+func (a *roomLogArchive) load(roomID string) ([]RoomEvent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	events, ok := a.logs[roomID]
+	return events, ok
+}
@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+func TestReplayedLogReachesFinalBoard(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	roomID, state := startMultiplayerGame(t, host, guest)
+
+	if _, err := srv.ReplayRoomEvents(context.Background(), roomID); !errors.Is(err, apperrors.ErrGameNotEnded) {
+		t.Fatalf("got error %v replaying a running game, want %v", err, apperrors.ErrGameNotEnded)
+	}
+
+	players := map[games.PlayerSide]*testClient{
+		colorOf(t, state, host.id):  host,
+		colorOf(t, state, guest.id): guest,
+	}
+	mirror := games.NewFlipFlopGame(games.FlipFlop3x3)
+	for range 2 {
+		mover := players[mirror.CurrentTurn()]
+		move := firstValidMove(t, mirror)
+		applyMove(t, mirror, move)
+		mover.send(MsgTypeMove, roomID, move)
+		mover.expect(MsgTypeAck)
+	}
+	host.send(MsgTypeSendMessage, roomID, ChatMessage{Content: "good game"})
+	guest.expect(MsgTypeChat)
+	guest.send(MsgTypeForfeit, roomID, nil)
+	var ended GameEnded
+	host.expectPayload(MsgTypeGameEnd, &ended)
+	if ended.Reason != EndReasonForfeit {
+		t.Fatalf("got reason %q, want %q", ended.Reason, EndReasonForfeit)
+	}
+
+	live := srv.GetGameRoom(roomID).Details(true)
+	replayed, err := srv.ReplayRoomEvents(context.Background(), roomID)
+	if err != nil {
+		t.Fatalf("replaying room: %v", err)
+	}
+
+	if replayed.GameState.Board != live.GameState.Board || replayed.GameState.Board != mirror.GetBoardString() {
+		t.Fatalf("got board %s after replay, want %s", replayed.GameState.Board, live.GameState.Board)
+	}
+	if len(replayed.GameState.MoveHistory) != 2 {
+		t.Fatalf("got %d moves after replay, want 2", len(replayed.GameState.MoveHistory))
+	}
+	if replayed.Result == nil || *replayed.Result != *live.Result {
+		t.Fatalf("got result %v after replay, want %v", replayed.Result, *live.Result)
+	}
+	if len(replayed.PlayerMessages) != 1 || replayed.PlayerMessages[0].Message != "good game" {
+		t.Fatalf("got player messages %v after replay", replayed.PlayerMessages)
+	}
+}
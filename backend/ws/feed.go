@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"slices"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+const (
+	FeedBufferSize       = 128 // Number of recent events a room keeps for clients that resync or resume
+	FeedSubscriberBuffer = 32  // Number of events queued for a feed client before it is dropped as too slow
+)
+
+// Message types streamed to spectator feed subscribers.
+var feedMsgTypes = []MsgType{
+	MsgTypeGameState,
+	MsgTypeGameStart,
+	MsgTypeMove,
+	MsgTypeGameEnd,
+	MsgTypeSeriesEnd,
+	MsgTypeChat,
+}
+
+// An event sent to an audience of a room. Data is the encoded message, which carries the event ID as its seq.
+type FeedEvent struct {
+	ID   uint64
+	Type MsgType
+	Data []byte
+}
+
+// A client following the spectator feed of a room.
+type FeedSubscription struct {
+	Backlog []FeedEvent      // Events to send before reading from Events
+	Events  <-chan FeedEvent // Closed when the room closes or the client falls too far behind
+	events  chan FeedEvent
+}
+
+// Sequenced stream of the events sent to one audience of a room, either the players or the spectators.
+// Recent events are kept in a ring buffer so that clients can catch up on the events they missed.
+// Subscribers follow the stream without taking a spectator slot in the room.
+type roomFeed struct {
+	roomID      string
+	seq         uint64
+	ring        [FeedBufferSize]FeedEvent
+	subscribers map[*FeedSubscription]struct{}
+	closed      bool
+	view        func(payload any) any // Changes the payloads of feeds that reach clients outside the room
+}
+
+func newRoomFeed(roomID string) *roomFeed {
+	return &roomFeed{roomID: roomID, subscribers: make(map[*FeedSubscription]struct{})}
+}
+
+// Assigns the next sequence number to an event, records it and sends it to the subscribers.
+// Subscribers whose queue is full are dropped, they can resume from the last event they received.
+func (f *roomFeed) publish(msgType MsgType, payload any) FeedEvent {
+	if f.view != nil {
+		payload = f.view(payload)
+	}
+
+	f.seq++
+	event := FeedEvent{
+		ID:   f.seq,
+		Type: msgType,
+		Data: NewRoomMessage(f.roomID, msgType, payload, "", f.seq),
+	}
+	f.ring[f.seq%FeedBufferSize] = event
+
+	if slices.Contains(feedMsgTypes, msgType) {
+		for sub := range f.subscribers {
+			select {
+			case sub.events <- event:
+			default:
+				f.unsubscribe(sub)
+			}
+		}
+	}
+	return event
+}
+
+// Returns the buffered events after lastID, or false if some of them are no longer buffered.
+func (f *roomFeed) since(lastID uint64) ([]FeedEvent, bool) {
+	if lastID > f.seq || f.seq-lastID > FeedBufferSize {
+		return nil, false
+	}
+
+	events := make([]FeedEvent, 0, f.seq-lastID)
+	for id := lastID + 1; id <= f.seq; id++ {
+		events = append(events, f.ring[id%FeedBufferSize])
+	}
+	return events, true
+}
+
+// Adds a subscriber. A client resuming after lastEventID receives the events it missed,
+// otherwise it starts from the snapshot, which is tagged with the ID of the latest event.
+func (f *roomFeed) subscribe(lastEventID *uint64, snapshot func() []byte) (*FeedSubscription, error) {
+	if f.closed {
+		return nil, apperrors.ErrRoomClosed
+	}
+
+	sub := &FeedSubscription{events: make(chan FeedEvent, FeedSubscriberBuffer)}
+	sub.Events = sub.events
+
+	if lastEventID != nil {
+		if missed, ok := f.since(*lastEventID); ok {
+			sub.Backlog = slices.DeleteFunc(missed, func(event FeedEvent) bool {
+				return !slices.Contains(feedMsgTypes, event.Type)
+			})
+		}
+	}
+	if sub.Backlog == nil {
+		sub.Backlog = []FeedEvent{{ID: f.seq, Type: MsgTypeGameState, Data: snapshot()}}
+	}
+
+	f.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Removes a subscriber and closes its channel.
+func (f *roomFeed) unsubscribe(sub *FeedSubscription) {
+	if _, ok := f.subscribers[sub]; !ok {
+		return
+	}
+	delete(f.subscribers, sub)
+	close(sub.events)
+}
+
+// Ends the feed, closing every subscriber.
+func (f *roomFeed) close() {
+	for sub := range f.subscribers {
+		f.unsubscribe(sub)
+	}
+	f.closed = true
+}
+
+// Returns the feed of the players or the spectators.
+// Must be called from the room goroutine.
+func (gr *GameRoom) feedFor(spectators bool) *roomFeed {
+	if spectators {
+		return gr.spectatorFeed
+	}
+	return gr.playerFeed
+}
+
+// Follows the spectator feed of the room, resuming after lastEventID if it is set.
+func (gr *GameRoom) SubscribeFeed(lastEventID *uint64) (sub *FeedSubscription, err error) {
+	gr.do(func() { sub, err = gr.subscribeFeed(lastEventID) })
+	return sub, err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) subscribeFeed(lastEventID *uint64) (*FeedSubscription, error) {
+	return gr.spectatorFeed.subscribe(lastEventID, func() []byte {
+		return NewRoomMessage(gr.ID, MsgTypeGameState, gr.publicGameState(), "", gr.spectatorFeed.seq)
+	})
+}
+
+// Stops following the spectator feed of the room.
+func (gr *GameRoom) UnsubscribeFeed(sub *FeedSubscription) {
+	gr.do(func() { gr.spectatorFeed.unsubscribe(sub) })
+}
@@ -0,0 +1,90 @@
+package ws
+
+import "testing"
+
+// Returns a feed with the given number of published events, every third one a type spectators do not follow.
+func feedWithEvents(published int) *roomFeed {
+	feed := newRoomFeed("room")
+	for i := range published {
+		msgType := MsgTypeMove
+		if i%3 == 2 {
+			msgType = MsgTypeRematchRequested
+		}
+		feed.publish(msgType, nil)
+	}
+	return feed
+}
+
+func TestRoomFeedSince(t *testing.T) {
+	tests := []struct {
+		name      string
+		published int
+		lastID    uint64
+		wantFirst uint64 // ID of the first returned event, when there are any
+		wantLen   int
+		wantOK    bool
+	}{
+		{name: "no events", published: 0, lastID: 0, wantOK: true},
+		{name: "up to date", published: 10, lastID: 10, wantOK: true},
+		{name: "missed some", published: 10, lastID: 4, wantFirst: 5, wantLen: 6, wantOK: true},
+		{name: "missed all", published: 10, lastID: 0, wantFirst: 1, wantLen: 10, wantOK: true},
+		{name: "ahead of the feed", published: 10, lastID: 11},
+		{name: "after wraparound", published: FeedBufferSize + 20, lastID: FeedBufferSize + 5, wantFirst: FeedBufferSize + 6, wantLen: 15, wantOK: true},
+		{name: "whole ring after wraparound", published: FeedBufferSize + 20, lastID: 20, wantFirst: 21, wantLen: FeedBufferSize, wantOK: true},
+		{name: "overwritten by wraparound", published: FeedBufferSize + 20, lastID: 19},
+		{name: "after several wraparounds", published: 3*FeedBufferSize + 1, lastID: 3 * FeedBufferSize, wantFirst: 3*FeedBufferSize + 1, wantLen: 1, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := feedWithEvents(tt.published).since(tt.lastID)
+			if ok != tt.wantOK || len(events) != tt.wantLen {
+				t.Fatalf("got %d events and ok %v, want %d events and ok %v", len(events), ok, tt.wantLen, tt.wantOK)
+			}
+			for i, event := range events {
+				if want := tt.wantFirst + uint64(i); event.ID != want {
+					t.Fatalf("got event %d at index %d, want %d", event.ID, i, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoomFeedSubscribeBacklog(t *testing.T) {
+	snapshot := func() []byte { return []byte("snapshot") }
+	id := func(id uint64) *uint64 { return &id }
+
+	tests := []struct {
+		name        string
+		lastEventID *uint64
+		wantIDs     []uint64
+		wantState   bool // Starts from the snapshot instead of the missed events
+	}{
+		{name: "new subscriber", wantIDs: []uint64{FeedBufferSize + 10}, wantState: true},
+		{name: "resuming", lastEventID: id(FeedBufferSize + 5), wantIDs: []uint64{FeedBufferSize + 6, FeedBufferSize + 8, FeedBufferSize + 9}},
+		{name: "resuming up to date", lastEventID: id(FeedBufferSize + 10), wantIDs: []uint64{}},
+		{name: "resuming too late", lastEventID: id(1), wantIDs: []uint64{FeedBufferSize + 10}, wantState: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := feedWithEvents(FeedBufferSize + 10)
+			sub, err := feed.subscribe(tt.lastEventID, snapshot)
+			if err != nil {
+				t.Fatalf("subscribing: %v", err)
+			}
+
+			if len(sub.Backlog) != len(tt.wantIDs) {
+				t.Fatalf("got backlog of %d events, want %d", len(sub.Backlog), len(tt.wantIDs))
+			}
+			for i, event := range sub.Backlog {
+				if event.ID != tt.wantIDs[i] {
+					t.Fatalf("got event %d at index %d, want %d", event.ID, i, tt.wantIDs[i])
+				}
+				if isState := event.Type == MsgTypeGameState; isState != tt.wantState {
+					t.Fatalf("got %s event in the backlog", event.Type)
+				}
+			}
+		})
+	}
+}
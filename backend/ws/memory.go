@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/lxzan/gws"
+)
+
+// A peer that keeps the messages sent to it in memory instead of writing them to a network connection.
+// Lets bots, simulations and load tests run whole games in-process through the same server logic.
+//
+// The owner reads messages from Messages and feeds the server with Server.Receive.
+// The channel is closed when the server closes the peer, after which the owner must call Server.Disconnect.
+type MemoryPeer struct {
+	session     gws.SessionStorage
+	messages    chan []byte
+	mu          sync.Mutex
+	closed      bool
+	closeCode   uint16
+	closeReason string
+}
+
+// Returns a peer for the given client that buffers up to size messages.
+// A new client ID is assigned by the server on connect if clientID is empty.
+func NewMemoryPeer(clientID string, size int) *MemoryPeer {
+	p := &MemoryPeer{
+		session:  gws.NewConcurrentMap[string, any](),
+		messages: make(chan []byte, size),
+	}
+	p.session.Store("protocol", strconv.Itoa(ProtocolVersionCurrent))
+	if clientID != "" {
+		p.session.Store("client_id", clientID)
+	}
+	return p
+}
+
+func (p *MemoryPeer) Session() gws.SessionStorage {
+	return p.session
+}
+
+// Returns the messages sent to the peer, in order.
+func (p *MemoryPeer) Messages() <-chan []byte {
+	return p.messages
+}
+
+// Queues a message for the owner. Fails instead of blocking if the buffer is full.
+func (p *MemoryPeer) Send(msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return apperrors.ErrPeerClosed
+	}
+
+	// Broadcast messages are shared between peers, so each one keeps its own copy
+	select {
+	case p.messages <- slices.Clone(msg):
+		return nil
+	default:
+		return apperrors.ErrPeerBufferFull
+	}
+}
+
+func (p *MemoryPeer) SendAsync(msg []byte, callback func(error)) {
+	err := p.Send(msg)
+	if callback != nil {
+		callback(err)
+	}
+}
+
+func (p *MemoryPeer) Close(code uint16, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return apperrors.ErrPeerClosed
+	}
+
+	p.closed = true
+	p.closeCode = code
+	p.closeReason = reason
+	close(p.messages)
+	return nil
+}
+
+// Returns the close code and reason, and whether the peer has been closed.
+func (p *MemoryPeer) CloseStatus() (code uint16, reason string, closed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closeCode, p.closeReason, p.closed
+}
@@ -2,6 +2,7 @@ package ws
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/CDavidSV/online-flip-flop/ai"
 	"github.com/CDavidSV/online-flip-flop/games"
@@ -17,7 +18,7 @@ const (
 	MsgTypeJoinRoom         MsgType = "join"              // Join an existing game room
 	MsgTypeGameState        MsgType = "game_state"        // Current state of the game
 	MsgTypeJoinedRoom       MsgType = "joined"            // Response after joining a room
-	MsgTypeLeaveRoom        MsgType = "leave"             // Leave the current game room
+	MsgTypeLeaveRoom        MsgType = "leave"             // Leave a game room
 	MsgTypeLeftRoom         MsgType = "left"              // Response after leaving a room
 	MsgTypeKicked           MsgType = "kicked"            // Notification that a player has been kicked from the room
 	MsgPlayerLeftRoom       MsgType = "player_left"       // Notification that a player has left the room
@@ -31,29 +32,60 @@ const (
 	MsgTypeRematchRequested MsgType = "rematch_requested" // Notification that a rematch has been requested
 	MsgTypeRematchCancelled MsgType = "rematch_cancelled" // Notification that a rematch request has been cancelled
 	MsgTypeForfeit          MsgType = "forfeit"           // Forfeit the game
+	MsgTypeAbort            MsgType = "abort"             // Abort the game before making the first move
+	MsgTypeSeriesEnd        MsgType = "series_end"        // Notification that a match series has been decided
+	MsgTypeAbandonClaimable MsgType = "abandon_claimable" // Notification that a disconnected player's grace period has run out
+	MsgTypeClaimAbandon     MsgType = "claim_abandon"     // Claim a win or draw after the opponent abandoned the game
 	MsgTypeSendMessage      MsgType = "message"           // Send a message
 	MsgTypeChat             MsgType = "chat"              // New chat message
+	MsgTypeAnnouncement     MsgType = "announcement"      // Server-wide announcement from the operators
+	MsgTypeResync           MsgType = "resync"            // Request or response with the room events a client missed
 	MsgTypeError            MsgType = "error"             // Error message
 )
 
+// Message types that clients are allowed to send.
+var incomingMsgTypes = []MsgType{
+	MsgTypeCreateRoom,
+	MsgTypeJoinRoom,
+	MsgTypeLeaveRoom,
+	MsgTypeMove,
+	MsgTypeForfeit,
+	MsgTypeAbort,
+	MsgTypeGameState,
+	MsgTypeSendMessage,
+	MsgTypeRematch,
+	MsgTypeCancelRematch,
+	MsgTypeClaimAbandon,
+	MsgTypeResync,
+}
+
 // Incomming message from a websocket connection.
 type IncomingMessage struct {
 	Type      MsgType         `json:"type" validate:"required"`             // The type or action of the message
 	Payload   json.RawMessage `json:"payload,omitempty"`                    // Any additional data required for the type of message.
 	RequestID string          `json:"request_id" validate:"required,uuid4"` // Required for tracking requests/responses.
+	RoomID    string          `json:"room_id,omitempty"`                    // Room the message is about. Optional while the client is in a single room.
 }
 
 type OutgoingMessage struct {
 	Type      MsgType `json:"type"`
 	Payload   any     `json:"payload,omitempty"`
 	RequestID string  `json:"request_id,omitempty"`
+	RoomID    string  `json:"room_id,omitempty"` // Room the message is about
+	Seq       uint64  `json:"seq,omitempty"`     // Sequence number of the room event the message is, or refers to
 }
 
 type CreateRoom struct {
-	GameType   games.GameType  `json:"game_type" validate:"required"`
-	GameMode   GameMode        `json:"game_mode" validate:"required"`
-	Difficulty ai.AIDifficulty `json:"difficulty,omitempty"`
-	Username   string          `json:"username" validate:"required,min=2,max=20"`
+	GameType       games.GameType  `json:"game_type" validate:"required"`
+	GameMode       GameMode        `json:"game_mode" validate:"required"`
+	Difficulty     ai.AIDifficulty `json:"difficulty,omitempty"`
+	Username       string          `json:"username" validate:"required,min=2,max=20"`
+	SpectatorDelay SpectatorDelay  `json:"spectator_delay,omitempty"`
+	SeriesLength   int             `json:"series_length,omitempty" validate:"omitempty,oneof=1 3 5 7"`
+	ColorPolicy    ColorPolicy     `json:"color_policy,omitempty" validate:"omitempty,oneof=alternate keep random"`
+
+	// Seconds a disconnected player has to come back before the opponent can claim the game
+	AbandonGracePeriod int `json:"abandon_grace_period,omitempty" validate:"omitempty,min=10,max=600"`
 }
 
 type JoinRoom struct {
@@ -61,10 +93,90 @@ type JoinRoom struct {
 	Username string `json:"username" validate:"omitempty,gte=2,lte=20"`
 }
 
+type ClaimAbandon struct {
+	Result AbandonClaim `json:"result" validate:"required,oneof=win draw"`
+}
+
 type ChatMessage struct {
 	Content string `json:"content" validate:"required,min=1,max=1000"`
 }
 
+type Resync struct {
+	LastSeq uint64 `json:"last_seq"` // Sequence number of the last room event the client processed
+}
+
+// ------------------ Outgoing payloads ------------------
+
+type Connected struct {
+	ClientID string       `json:"client_id"`
+	Protocol ProtocolInfo `json:"protocol"`
+}
+
+type RoomCreated struct {
+	RoomID      string `json:"room_id"`
+	IsSpectator bool   `json:"is_spectator"`
+}
+
+type RoomJoined struct {
+	IsSpectator bool           `json:"is_spectator"`
+	GameMode    GameMode       `json:"game_mode"`
+	GameType    games.GameType `json:"game_type"`
+	GameState   GameState      `json:"game_state"`
+	Messages    []SavedMessage `json:"messages"`
+}
+
+type Kicked struct {
+	Reason string `json:"reason"`
+}
+
+type Announcement struct {
+	Message string `json:"message"`
+}
+
+// Notification about a player, such as a rematch request or a claimable abandonment.
+// Spectators get the color of the player instead of their ID.
+type PlayerUpdate struct {
+	PlayerID string            `json:"player_id,omitempty"`
+	Color    *games.PlayerSide `json:"color,omitempty"`
+}
+
+type PlayerLeft struct {
+	PlayerID        string            `json:"player_id,omitempty"`
+	Color           *games.PlayerSide `json:"color,omitempty"`            // Sent to spectators instead of the player ID
+	AbandonDeadline *time.Time        `json:"abandon_deadline,omitempty"` // Set if the opponent can claim the game once it passes
+	GracePeriod     int               `json:"grace_period,omitempty"`     // Seconds the player has to come back
+}
+
+type PlayerRejoined struct {
+	PlayerID  string            `json:"player_id,omitempty"`
+	Color     *games.PlayerSide `json:"color,omitempty"` // Sent to spectators instead of the player ID
+	GameState GameState         `json:"game_state"`
+}
+
+type MoveMade struct {
+	PlayerID string           `json:"player_id,omitempty"` // Left out for spectators
+	Color    games.PlayerSide `json:"color"`
+	Move     json.RawMessage  `json:"move"` // Game-specific move data
+	Board    string           `json:"board"`
+}
+
+type GameEnded struct {
+	Reason EndReason         `json:"reason"`
+	Winner *games.PlayerSide `json:"winner,omitempty"` // Not set if the game has no winner
+	Series *SeriesState      `json:"series,omitempty"`
+}
+
+type SeriesEnded struct {
+	Winner *int       `json:"winner,omitempty"` // Seat of the player that won the series, not set if tied
+	Scores [2]float64 `json:"scores"`           // Points by seat, in the order the players are listed in the room
+}
+
+// Range of protocol versions the server supports, sent to clients that need to upgrade.
+type ProtocolRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
 // Constructs a new error message in JSON format to be sent through websocket.
 func NewErrorMessage(appErr *apperrors.AppError, requestID string) []byte {
 	errMsg := OutgoingMessage{
@@ -72,19 +184,29 @@ func NewErrorMessage(appErr *apperrors.AppError, requestID string) []byte {
 		Payload:   appErr,
 		RequestID: requestID,
 	}
-
-	errMsgJSON, _ := json.Marshal(errMsg)
-	return errMsgJSON
+	return errMsg.encode()
 }
 
 // Constructs a new message in JSON format to be sent through websocket.
 func NewMessage(action MsgType, payload any, requestID string) []byte {
+	return NewRoomMessage("", action, payload, requestID, 0)
+}
+
+// Constructs a new message about a room. The seq is the sequence number of the room event
+// the message is or refers to, and is left out if it is zero.
+func NewRoomMessage(roomID string, action MsgType, payload any, requestID string, seq uint64) []byte {
 	msg := OutgoingMessage{
 		Type:      action,
 		Payload:   payload,
 		RequestID: requestID,
+		RoomID:    roomID,
+		Seq:       seq,
 	}
+	return msg.encode()
+}
 
-	msgJSON, _ := json.Marshal(msg)
-	return msgJSON
+// Encodes the message in JSON format.
+func (m OutgoingMessage) encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
 }
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
+	"github.com/lxzan/gws"
+)
+
+const (
+	OutboundQueueSize    = 256              // Messages queued for a connection before the slow consumer policy applies
+	OutboundWriteTimeout = 10 * time.Second // Time a single write can take before the connection is considered stuck
+
+	CloseSlowConsumer uint16 = 1008 // Close code sent to clients that fall too far behind
+)
+
+// Message types a slow client can miss. Clients on protocol version 2 notice the gap in the event sequence and resync.
+var droppableMsgTypes = []MsgType{
+	MsgTypeChat,
+	MsgTypeAnnouncement,
+}
+
+// An encoded message waiting to be written to a connection.
+type outboundMessage struct {
+	msgType  MsgType // Empty for messages the slow consumer policy leaves alone
+	opcode   gws.Opcode
+	data     []byte
+	callback func(error)
+}
+
+// Close frame requested for a connection, written once the queued messages are sent.
+type closeFrame struct {
+	code   uint16
+	reason string
+}
+
+// Bounded queue of the messages waiting to be written to a websocket connection.
+// A writer goroutine drains it, so rooms that send messages never wait on the network.
+//
+// When the queue is full, droppable messages are dropped, and if that does not free up room
+// the client is disconnected as a slow consumer.
+type outboundQueue struct {
+	mu       sync.Mutex
+	messages []outboundMessage
+	closing  *closeFrame
+	stopped  bool
+	ready    chan struct{} // Wakes up the writer
+}
+
+func newOutboundQueue() *outboundQueue {
+	return &outboundQueue{ready: make(chan struct{}, 1)}
+}
+
+// Wakes up the writer. Requires the queue lock before calling.
+func (q *outboundQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Adds a message to the queue, applying the slow consumer policy if it is full.
+// The callback of the message is not called if an error is returned.
+func (q *outboundQueue) push(msg outboundMessage) error {
+	q.mu.Lock()
+	if q.stopped || q.closing != nil {
+		q.mu.Unlock()
+		return apperrors.ErrPeerClosed
+	}
+
+	if len(q.messages) < OutboundQueueSize {
+		q.messages = append(q.messages, msg)
+		q.signal()
+		q.mu.Unlock()
+		return nil
+	}
+
+	// Callbacks of the messages removed from the queue are called after unlocking
+	var discarded []outboundMessage
+	var err error
+	switch {
+	case slices.Contains(droppableMsgTypes, msg.msgType):
+		metrics.OutboundDropped.WithLabelValues("drop").Inc()
+		err = apperrors.ErrPeerBufferFull
+	case q.evictDroppable(&discarded):
+		metrics.OutboundDropped.WithLabelValues("drop").Inc()
+		q.messages = append(q.messages, msg)
+	default:
+		metrics.OutboundDropped.WithLabelValues("disconnect").Inc()
+		discarded = q.messages
+		q.messages = nil
+		q.closing = &closeFrame{code: CloseSlowConsumer, reason: "slow_consumer"}
+		q.signal()
+		err = apperrors.ErrPeerBufferFull
+	}
+	q.mu.Unlock()
+
+	for _, m := range discarded {
+		m.done(apperrors.ErrPeerBufferFull)
+	}
+	return err
+}
+
+// Removes the oldest droppable message to make room for a new one.
+// Requires the queue lock before calling.
+func (q *outboundQueue) evictDroppable(discarded *[]outboundMessage) bool {
+	for i, queued := range q.messages {
+		if slices.Contains(droppableMsgTypes, queued.msgType) {
+			*discarded = append(*discarded, queued)
+			q.messages = slices.Delete(q.messages, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Requests a close frame, which is written after the queued messages.
+func (q *outboundQueue) close(code uint16, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped || q.closing != nil {
+		return apperrors.ErrPeerClosed
+	}
+	q.closing = &closeFrame{code: code, reason: reason}
+	q.signal()
+	return nil
+}
+
+// Stops the writer once the connection is closed. Messages still queued are discarded.
+func (q *outboundQueue) stop() {
+	q.mu.Lock()
+	discarded := q.messages
+	q.messages = nil
+	q.stopped = true
+	q.signal()
+	q.mu.Unlock()
+
+	for _, m := range discarded {
+		m.done(apperrors.ErrPeerClosed)
+	}
+}
+
+// Writes queued messages to the connection until it is closed.
+// A write that fails or times out closes the connection.
+func (q *outboundQueue) run(socket *gws.Conn) {
+	for range q.ready {
+		q.mu.Lock()
+		messages, closing, stopped := q.messages, q.closing, q.stopped
+		q.messages = nil
+		q.mu.Unlock()
+
+		for i, m := range messages {
+			socket.SetWriteDeadline(time.Now().Add(OutboundWriteTimeout))
+			err := socket.WriteMessage(m.opcode, m.data)
+			m.done(err)
+			if err != nil {
+				for _, rest := range messages[i+1:] {
+					rest.done(err)
+				}
+				socket.NetConn().Close()
+				return
+			}
+		}
+
+		if stopped {
+			return
+		}
+		if closing != nil {
+			socket.SetWriteDeadline(time.Now().Add(OutboundWriteTimeout))
+			socket.WriteClose(closing.code, []byte(closing.reason))
+			return
+		}
+	}
+}
+
+// Reports the result of writing the message.
+func (m outboundMessage) done(err error) {
+	if m.callback != nil {
+		m.callback(err)
+	}
+}
@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	tests := []struct {
+		name              string
+		announcements     int // Announcements broadcast while the client is not reading
+		responses         int // Messages then sent directly to the client
+		wantAnnouncements int
+		wantResponses     int
+		wantClose         uint16
+	}{
+		{name: "fits in the queue", announcements: OutboundQueueSize - 1, responses: 1, wantAnnouncements: OutboundQueueSize - 1, wantResponses: 1},
+		{name: "announcements are dropped", announcements: OutboundQueueSize + 10, wantAnnouncements: OutboundQueueSize},
+		{name: "response evicts an announcement", announcements: OutboundQueueSize, responses: 1, wantAnnouncements: OutboundQueueSize - 1, wantResponses: 1},
+		{name: "responses disconnect", responses: OutboundQueueSize + 1, wantClose: CloseSlowConsumer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			socket := dialTestServer(t, srv, "protocol=2")
+			var connected Connected
+			if msg := socket.nextJSON(); msg.Type != MsgTypeConnected || json.Unmarshal(msg.Payload, &connected) != nil {
+				t.Fatalf("got %s, want %s", msg.Type, MsgTypeConnected)
+			}
+			peer, _ := srv.clients.Load(connected.ClientID)
+
+			// Holds up the writer, as a client that stopped reading would, until the messages are queued
+			written, release := make(chan struct{}), make(chan struct{})
+			peer.SendAsync(NewMessage(MsgTypeAck, nil, "stalled"), func(error) {
+				close(written)
+				<-release
+			})
+			<-written
+
+			for i := range tt.announcements {
+				srv.announce(fmt.Sprintf("announcement %d", i))
+			}
+			for i := range tt.responses {
+				sendMessage(peer, OutgoingMessage{Type: MsgTypeAck, RequestID: fmt.Sprintf("req-%d", i)}, nil)
+			}
+			close(release)
+
+			if msg := socket.nextJSON(); msg.RequestID != "stalled" {
+				t.Fatalf("got %s before the stalled message", msg.Type)
+			}
+			if tt.wantClose != 0 {
+				if closeErr := socket.expectClose(); closeErr.Code != tt.wantClose || string(closeErr.Reason) != "slow_consumer" {
+					t.Fatalf("got close %d %q, want %d slow_consumer", closeErr.Code, closeErr.Reason, tt.wantClose)
+				}
+				return
+			}
+
+			// The announcements that were kept are written before the responses
+			announcements, responses := 0, 0
+			for range tt.wantAnnouncements + tt.wantResponses {
+				switch msg := socket.nextJSON(); msg.Type {
+				case MsgTypeAnnouncement:
+					if responses > 0 {
+						t.Fatalf("got an announcement after a response")
+					}
+					announcements++
+				case MsgTypeAck:
+					responses++
+				}
+			}
+			if announcements != tt.wantAnnouncements || responses != tt.wantResponses {
+				t.Fatalf("got %d announcements and %d responses, want %d and %d", announcements, responses, tt.wantAnnouncements, tt.wantResponses)
+			}
+		})
+	}
+}
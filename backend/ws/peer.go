@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
+	"github.com/lxzan/gws"
+)
+
+// A connected client the server can send messages to, independent of the transport carrying them.
+// Rooms and the server only talk to clients through this interface.
+type Peer interface {
+	// Per-connection state, such as the client ID and the room the client is in.
+	Session() gws.SessionStorage
+
+	// Sends an encoded message and waits until it is written.
+	Send(msg []byte) error
+
+	// Sends an encoded message without waiting. The callback, if provided, receives the write error.
+	SendAsync(msg []byte, callback func(error))
+
+	// Closes the connection with a websocket close code and reason.
+	Close(code uint16, reason string) error
+}
+
+// Implemented by peers that queue messages by type, so that the slow consumer policy knows which ones it can drop.
+type typedSender interface {
+	sendTyped(msgType MsgType, msg []byte, callback func(error))
+}
+
+// Wraps the callback of a message so that the message is counted in the metrics once it is written.
+func countSent(msgType MsgType, callback func(error)) func(error) {
+	return func(err error) {
+		if err == nil {
+			metrics.MessagesSent.WithLabelValues(string(msgType)).Inc()
+		}
+		if callback != nil {
+			callback(err)
+		}
+	}
+}
+
+// Sends an encoded message of the given type and waits until it is written.
+func sendEncoded(peer Peer, msgType MsgType, msg []byte) error {
+	err := peer.Send(msg)
+	if err == nil {
+		metrics.MessagesSent.WithLabelValues(string(msgType)).Inc()
+	}
+	return err
+}
+
+// Sends a message without waiting for it to be written.
+func sendMessage(peer Peer, msg OutgoingMessage, callback func(error)) {
+	peer.SendAsync(msg.encode(), countSent(msg.Type, callback))
+}
+
+// Sends a message and waits until it is written.
+func sendMessageSync(peer Peer, msg OutgoingMessage) error {
+	return sendEncoded(peer, msg.Type, msg.encode())
+}
+
+// Sends the same encoded message to many peers.
+// The message is only encoded once for each encoding and queued on every websocket peer.
+type broadcaster struct {
+	msg     []byte
+	msgType MsgType
+	msgpack []byte
+	sent    func(error) // Counts the message for every peer it is written to
+}
+
+func newBroadcaster(msgType MsgType, msg []byte) *broadcaster {
+	return &broadcaster{msg: msg, msgType: msgType, sent: countSent(msgType, nil)}
+}
+
+// Sends the message to a peer.
+func (b *broadcaster) Broadcast(peer Peer) error {
+	if p, ok := peer.(*wsPeer); ok {
+		msg := outboundMessage{msgType: b.msgType, opcode: gws.OpcodeText, data: b.msg, callback: b.sent}
+		if p.encoding == EncodingMsgpack {
+			if b.msgpack == nil {
+				data, err := jsonToMsgpack(b.msg)
+				if err != nil {
+					return err
+				}
+				b.msgpack = data
+			}
+			msg.opcode, msg.data = gws.OpcodeBinary, b.msgpack
+		}
+		return p.queue.push(msg)
+	}
+
+	if p, ok := peer.(typedSender); ok {
+		p.sendTyped(b.msgType, b.msg, b.sent)
+		return nil
+	}
+	peer.SendAsync(b.msg, b.sent)
+	return nil
+}
@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMessagesAreCountedOnceWritten(t *testing.T) {
+	tests := []struct {
+		name      string
+		send      func(peer Peer)
+		wantCount float64
+	}{
+		{
+			name:      "direct message",
+			send:      func(peer Peer) { sendMessage(peer, OutgoingMessage{Type: MsgTypeAnnouncement}, nil) },
+			wantCount: 1,
+		},
+		{
+			name:      "response",
+			send:      func(peer Peer) { respond(peer, OutgoingMessage{Type: MsgTypeAnnouncement, RequestID: "req"}) },
+			wantCount: 1,
+		},
+		{
+			name: "broadcast",
+			send: func(peer Peer) {
+				b := newBroadcaster(MsgTypeAnnouncement, NewMessage(MsgTypeAnnouncement, nil, ""))
+				b.Broadcast(peer)
+				b.Broadcast(peer)
+			},
+			wantCount: 2,
+		},
+		{
+			name: "message that does not fit the buffer",
+			send: func(peer Peer) {
+				sendMessage(peer, OutgoingMessage{Type: MsgTypeAnnouncement}, nil)
+				sendMessage(peer, OutgoingMessage{Type: MsgTypeAnnouncement}, nil)
+				sendMessage(peer, OutgoingMessage{Type: MsgTypeAnnouncement}, nil)
+			},
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := metrics.MessagesSent.WithLabelValues(string(MsgTypeAnnouncement))
+			before := testutil.ToFloat64(sent)
+
+			tt.send(NewMemoryPeer("client", 2))
+			if got := testutil.ToFloat64(sent) - before; got != tt.wantCount {
+				t.Fatalf("got %v messages counted, want %v", got, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+const (
+	ProtocolVersionMin     = 1 // Oldest protocol version clients can still connect with
+	ProtocolVersionCurrent = 3 // Protocol version spoken by the current frontend
+
+	CloseUpgradeRequired uint16 = 4426 // Close code sent to clients using an unsupported protocol version
+)
+
+type Feature string
+
+const (
+	FeatureEventSeq      Feature = "event_seq"      // Room events and acks carry a sequence number
+	FeatureResync        Feature = "resync"         // Clients can ask for the room events they missed
+	FeatureRequestReplay Feature = "request_replay" // Retried requests are answered with the original responses
+	FeatureMultiRoom     Feature = "multi_room"     // Clients can be in several rooms, room messages carry a room ID
+)
+
+// Protocol version each feature was introduced in.
+var featureVersions = map[Feature]int{
+	FeatureRequestReplay: 1,
+	FeatureEventSeq:      2,
+	FeatureResync:        2,
+	FeatureMultiRoom:     3,
+}
+
+// Protocol version each message type was introduced in. Types not listed are part of version 1.
+var msgTypeVersions = map[MsgType]int{
+	MsgTypeResync: 2,
+}
+
+// Checks if a feature is available to clients on the given protocol version.
+func featureSupported(feature Feature, version int) bool {
+	return featureVersions[feature] <= version
+}
+
+// Protocol negotiated with a client, sent in the connected message.
+type ProtocolInfo struct {
+	Version  int       `json:"version"`
+	Min      int       `json:"min"`
+	Max      int       `json:"max"`
+	Features []Feature `json:"features"`
+	Encoding Encoding  `json:"encoding"`
+}
+
+// Parses the protocol version requested by a client.
+// Clients that do not send one are assumed to be on version 1, which predates the negotiation.
+func ParseProtocolVersion(value string) (int, error) {
+	if value == "" {
+		return ProtocolVersionMin, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || !supportsProtocol(version) {
+		return 0, apperrors.ErrUpgradeRequired
+	}
+	return version, nil
+}
+
+// Checks if the server can talk to clients on the given protocol version.
+func supportsProtocol(version int) bool {
+	return version >= ProtocolVersionMin && version <= ProtocolVersionCurrent
+}
+
+// Returns the features available to clients on the given protocol version.
+func protocolFeatures(version int) []Feature {
+	features := make([]Feature, 0, len(featureVersions))
+	for feature, since := range featureVersions {
+		if since <= version {
+			features = append(features, feature)
+		}
+	}
+	slices.Sort(features)
+	return features
+}
+
+// Returns the protocol information of a client on the given version and encoding.
+func protocolInfo(version int, encoding Encoding) ProtocolInfo {
+	return ProtocolInfo{
+		Version:  version,
+		Min:      ProtocolVersionMin,
+		Max:      ProtocolVersionCurrent,
+		Features: protocolFeatures(version),
+		Encoding: encoding,
+	}
+}
+
+// Returns the protocol version a message type was introduced in.
+func MsgTypeVersion(msgType MsgType) int {
+	if since, ok := msgTypeVersions[msgType]; ok {
+		return since
+	}
+	return ProtocolVersionMin
+}
+
+// Checks if a message type exists in the given protocol version.
+func msgTypeSupported(msgType MsgType, version int) bool {
+	return MsgTypeVersion(msgType) <= version
+}
+
+// Compatibility shim for clients on an older protocol version.
+// Messages they do not know are dropped and fields added after their version are removed.
+type shimPeer struct {
+	Peer
+	version int
+}
+
+// Rewrites a message for the protocol version of the client.
+// Returns nil if the message should not be sent.
+func (p *shimPeer) translate(msg []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return msg
+	}
+
+	var msgType MsgType
+	json.Unmarshal(fields["type"], &msgType)
+	if !msgTypeSupported(msgType, p.version) {
+		return nil
+	}
+
+	if !featureSupported(FeatureEventSeq, p.version) {
+		delete(fields, "seq")
+	}
+	if !featureSupported(FeatureMultiRoom, p.version) {
+		delete(fields, "room_id")
+	}
+
+	translated, err := json.Marshal(fields)
+	if err != nil {
+		return msg
+	}
+	return translated
+}
+
+func (p *shimPeer) Send(msg []byte) error {
+	if msg = p.translate(msg); msg == nil {
+		return nil
+	}
+	return p.Peer.Send(msg)
+}
+
+func (p *shimPeer) SendAsync(msg []byte, callback func(error)) {
+	p.sendTyped("", msg, callback)
+}
+
+func (p *shimPeer) sendTyped(msgType MsgType, msg []byte, callback func(error)) {
+	if msg = p.translate(msg); msg == nil {
+		if callback != nil {
+			callback(nil)
+		}
+		return
+	}
+	if typed, ok := p.Peer.(typedSender); ok {
+		typed.sendTyped(msgType, msg, callback)
+		return
+	}
+	p.Peer.SendAsync(msg, callback)
+}
+
+// Returns the peer the server talks to for a connection, which is the compatibility shim for older clients.
+func sessionPeer(peer Peer) Peer {
+	if shim := mustLoad[*shimPeer](peer.Session(), "shim"); shim != nil {
+		return shim
+	}
+	return peer
+}
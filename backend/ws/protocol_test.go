@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+// Connects a new client that asks for the given protocol version, without waiting for the connected message.
+func connectTestClientOn(t *testing.T, srv *Server, protocol string) (*testClient, error) {
+	t.Helper()
+
+	c := &testClient{t: t, srv: srv, peer: NewMemoryPeer("", 256)}
+	c.peer.Session().Store("protocol", protocol)
+	return c, srv.Connect(c.peer)
+}
+
+func TestProtocolVersionsShapeMessages(t *testing.T) {
+	tests := []struct {
+		protocol    string
+		wantVersion int
+		wantSeq     bool // Room events carry their sequence number
+		wantRoomID  bool // Room events carry the room they are about
+		wantResync  bool // Resync requests are understood
+	}{
+		{protocol: "", wantVersion: 1},
+		{protocol: "1", wantVersion: 1},
+		{protocol: "2", wantVersion: 2, wantSeq: true, wantResync: true},
+		{protocol: "3", wantVersion: 3, wantSeq: true, wantRoomID: true, wantResync: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("version "+tt.protocol, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			host, err := connectTestClientOn(t, srv, tt.protocol)
+			if err != nil {
+				t.Fatalf("connecting client: %v", err)
+			}
+			var connected Connected
+			host.expectPayload(MsgTypeConnected, &connected)
+			host.id = connected.ClientID
+			if connected.Protocol.Version != tt.wantVersion {
+				t.Fatalf("got protocol version %d, want %d", connected.Protocol.Version, tt.wantVersion)
+			}
+
+			guest := connectTestClient(t, srv)
+			roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+			guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+
+			start := host.expect(MsgTypeGameStart)
+			if hasSeq := start.Seq != 0; hasSeq != tt.wantSeq {
+				t.Fatalf("got seq %d on the game start, want it sent %v", start.Seq, tt.wantSeq)
+			}
+			if hasRoomID := start.RoomID != ""; hasRoomID != tt.wantRoomID {
+				t.Fatalf("got room ID %q on the game start, want it sent %v", start.RoomID, tt.wantRoomID)
+			}
+
+			host.send(MsgTypeResync, roomID, Resync{LastSeq: 0})
+			if !tt.wantResync {
+				host.expectError(apperrors.ErrInvalidMsgType.Error())
+				return
+			}
+			host.expect(MsgTypeResync)
+		})
+	}
+}
+
+func TestUnsupportedProtocolVersionsAreRejected(t *testing.T) {
+	for _, protocol := range []string{"0", "4", "latest"} {
+		t.Run(protocol, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			client, err := connectTestClientOn(t, srv, protocol)
+			if err != apperrors.ErrUpgradeRequired {
+				t.Fatalf("got error %v, want %v", err, apperrors.ErrUpgradeRequired)
+			}
+
+			var payload struct {
+				Code    string        `json:"code"`
+				Details ProtocolRange `json:"details"`
+			}
+			client.expectPayload(MsgTypeError, &payload)
+			if payload.Code != apperrors.ErrUpgradeRequired.Error() || payload.Details != (ProtocolRange{Min: ProtocolVersionMin, Max: ProtocolVersionCurrent}) {
+				t.Fatalf("got error %q with range %+v", payload.Code, payload.Details)
+			}
+
+			if code, reason, closed := client.peer.CloseStatus(); !closed || code != CloseUpgradeRequired || reason != apperrors.ErrUpgradeRequired.Error() {
+				t.Fatalf("got close %d %q and closed %v, want %d %s", code, reason, closed, CloseUpgradeRequired, apperrors.ErrUpgradeRequired)
+			}
+			if connections := srv.connections.Load(); connections != 0 {
+				t.Fatalf("got %d connections after the client was rejected, want 0", connections)
+			}
+		})
+	}
+}
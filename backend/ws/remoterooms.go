@@ -0,0 +1,477 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+	"github.com/google/uuid"
+)
+
+const ClusterQueryTimeout = 5 * time.Second // Time a node waits for the owner of a room to answer a query
+
+type clusterQuery string
+
+const (
+	queryRoom       clusterQuery = "room"        // Spectator view of the room
+	queryRecord     clusterQuery = "record"      // Record of the last finished game in the room
+	queryFeed       clusterQuery = "feed"        // Follows the spectator feed of the room, answered with the backlog
+	queryDetails    clusterQuery = "details"     // Full state of the room with its chat history, for operators
+	queryClose      clusterQuery = "close"       // Closes the room on behalf of an operator
+	queryRooms      clusterQuery = "rooms"       // Summaries of the open rooms of the node
+	queryAdminRooms clusterQuery = "admin_rooms" // Details of every room of the node, for operators
+	queryKick       clusterQuery = "kick"        // Kicks a client connected to the node on behalf of an operator
+	queryLog        clusterQuery = "log"         // Event log of a room held or archived by the node
+	queryAnnounce   clusterQuery = "announce"    // Sends an announcement to the clients of the node, answered with their number
+)
+
+// Errors the owner of a room can answer a query with, mapped back from their codes.
+var clusterQueryErrors = []error{
+	apperrors.ErrRoomNotFound,
+	apperrors.ErrRoomClosed,
+	apperrors.ErrGameNotStarted,
+	apperrors.ErrGameNotEnded,
+	apperrors.ErrClientNotFound,
+}
+
+// Spectator view of a room, as returned by the REST API.
+type PublicRoom struct {
+	RoomSummary
+	GameState PublicGameState `json:"game_state"`
+}
+
+// Returns the room if this node holds it and it is still open.
+func (s *Server) openRoom(roomID string) *GameRoom {
+	room := s.GetGameRoom(roomID)
+	if room == nil || room.IsClosed() {
+		return nil
+	}
+	return room
+}
+
+// Returns the spectator view of a room, asking the node that owns it if it is not held here.
+func (s *Server) FindRoom(ctx context.Context, roomID string) (PublicRoom, error) {
+	if room := s.openRoom(roomID); room != nil {
+		return room.publicRoom(), nil
+	}
+
+	var view PublicRoom
+	err := s.queryOwner(ctx, roomID, clusterMessage{Query: queryRoom}, &view)
+	return view, err
+}
+
+// Returns the record of the last finished game in a room, asking the node that owns it if it is not held here.
+func (s *Server) FindGameRecord(ctx context.Context, roomID string) (GameRecord, error) {
+	if room := s.openRoom(roomID); room != nil {
+		return room.GetGameRecord()
+	}
+
+	var record GameRecord
+	err := s.queryOwner(ctx, roomID, clusterMessage{Query: queryRecord}, &record)
+	return record, err
+}
+
+// Returns the full state of a room with its chat history, asking the node that owns it if it is not held here.
+func (s *Server) FindRoomDetails(ctx context.Context, roomID string) (RoomDetails, error) {
+	if room := s.openRoom(roomID); room != nil {
+		return room.Details(true), nil
+	}
+
+	var details RoomDetails
+	err := s.queryOwner(ctx, roomID, clusterMessage{Query: queryDetails}, &details)
+	return details, err
+}
+
+// Returns the open rooms of every node of the cluster.
+// Nodes that do not answer are left out.
+func (s *Server) ListRooms(ctx context.Context) []RoomSummary {
+	rooms := s.localRooms()
+	for node, answer := range s.queryNodes(ctx, clusterMessage{Query: queryRooms}) {
+		var nodeRooms []RoomSummary
+		if err := answer.decode(&nodeRooms); err != nil {
+			s.logger.Error("Failed to list rooms of node", "node", node, "error", err)
+			continue
+		}
+		rooms = append(rooms, nodeRooms...)
+	}
+	return rooms
+}
+
+// Returns the details of every room of every node of the cluster, without chat history.
+// Nodes that do not answer are left out.
+func (s *Server) ListRoomDetails(ctx context.Context) []RoomDetails {
+	rooms := s.localRoomDetails()
+	for node, answer := range s.queryNodes(ctx, clusterMessage{Query: queryAdminRooms}) {
+		var nodeRooms []RoomDetails
+		if err := answer.decode(&nodeRooms); err != nil {
+			s.logger.Error("Failed to list room details of node", "node", node, "error", err)
+			continue
+		}
+		rooms = append(rooms, nodeRooms...)
+	}
+	return rooms
+}
+
+// Force-closes a room, asking the node that owns it to close it if it is not held here.
+func (s *Server) CloseRoom(ctx context.Context, roomID, reason string) error {
+	if s.GetGameRoom(roomID) != nil {
+		return s.closeRoom(roomID, reason)
+	}
+	return s.queryOwner(ctx, roomID, clusterMessage{Query: queryClose, Reason: reason}, nil)
+}
+
+// Returns the event log of a room, asking the other nodes of the cluster for it if it is not kept here.
+// The log of a room is not available while a game is in progress.
+func (s *Server) FindRoomEvents(ctx context.Context, roomID string) ([]RoomEvent, error) {
+	events, err := s.roomEvents(roomID)
+	if !errors.Is(err, apperrors.ErrRoomNotFound) {
+		return events, err
+	}
+
+	// The log is archived by the node that held the room, which no longer owns it once the room is closed
+	for _, answer := range s.queryNodes(ctx, clusterMessage{Query: queryLog, RoomID: roomID}) {
+		switch answerErr := answer.decode(&events); {
+		case answerErr == nil:
+			return events, nil
+		case !errors.Is(answerErr, apperrors.ErrRoomNotFound):
+			err = answerErr
+		}
+	}
+	return nil, err
+}
+
+// Sends an announcement to every client connected to the cluster.
+// Returns the number of clients the announcement was sent to.
+func (s *Server) Announce(ctx context.Context, message string) int {
+	sent := s.announce(message)
+
+	data, _ := json.Marshal(message)
+	for node, answer := range s.queryNodes(ctx, clusterMessage{Query: queryAnnounce, Data: data}) {
+		var nodeSent int
+		if err := answer.decode(&nodeSent); err != nil {
+			s.logger.Error("Failed to send announcement to node", "node", node, "error", err)
+			continue
+		}
+		sent += nodeSent
+	}
+	return sent
+}
+
+// Kicks a client out of every room they are in and closes their connection,
+// asking the other nodes of the cluster to do it if the client is not connected here.
+func (s *Server) KickClient(ctx context.Context, clientID, reason string) error {
+	if _, ok := s.clients.Load(clientID); ok {
+		return s.kickClient(clientID, reason)
+	}
+
+	// Only the node the client is connected to finds them
+	err := apperrors.ErrClientNotFound
+	for _, answer := range s.queryNodes(ctx, clusterMessage{Query: queryKick, ClientID: clientID, Reason: reason}) {
+		switch {
+		case answer.err == nil:
+			return nil
+		case !errors.Is(answer.err, apperrors.ErrClientNotFound):
+			err = answer.err
+		}
+	}
+	return err
+}
+
+// Follows the spectator feed of a room, resuming after lastEventID if it is set.
+// Feeds of rooms held by other nodes are relayed over the bus. The returned function stops following the feed.
+func (s *Server) SubscribeRoomFeed(ctx context.Context, roomID string, lastEventID *uint64) (*FeedSubscription, func(), error) {
+	if room := s.openRoom(roomID); room != nil {
+		sub, err := room.SubscribeFeed(lastEventID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sub, func() { room.UnsubscribeFeed(sub) }, nil
+	}
+
+	// Registered before asking so that no event sent right after the answer is missed
+	id := uuid.NewString()
+	feed := &remoteFeed{events: make(chan FeedEvent, FeedSubscriberBuffer)}
+	s.remoteFeeds.Store(id, feed)
+
+	var backlog []FeedEvent
+	node, err := s.queryOwnerAs(ctx, roomID, clusterMessage{ReplyTo: id, Query: queryFeed, LastEventID: lastEventID}, &backlog)
+	if err != nil {
+		s.remoteFeeds.Delete(id)
+		return nil, nil, err
+	}
+
+	sub := &FeedSubscription{Backlog: backlog, Events: feed.events}
+	return sub, func() {
+		s.remoteFeeds.Delete(id)
+		feed.close()
+		if err := s.publish(node, clusterMessage{Kind: clusterUnsubscribe, ReplyTo: id}); err != nil {
+			s.logger.Error("Failed to stop following remote feed", "room_id", roomID, "node", node, "error", err)
+		}
+	}, nil
+}
+
+// Asks the node that owns a room about it and decodes the answer into v, unless v is nil.
+func (s *Server) queryOwner(ctx context.Context, roomID string, msg clusterMessage, v any) error {
+	_, err := s.queryOwnerAs(ctx, roomID, msg, v)
+	return err
+}
+
+// Sends a query to the node that owns a room and waits for the answer, decoded into v unless v is nil.
+// Returns the node that answered.
+func (s *Server) queryOwnerAs(ctx context.Context, roomID string, msg clusterMessage, v any) (string, error) {
+	node, err := s.directory.Owner(ctx, roomID)
+	if err != nil && !errors.Is(err, apperrors.ErrRoomNotFound) {
+		s.logger.Error("Failed to look up room owner", "room_id", roomID, "error", err)
+	}
+	if err != nil || node == s.nodeID {
+		return "", apperrors.ErrRoomNotFound
+	}
+
+	msg.RoomID = roomID
+	data, err := s.queryNode(ctx, node, msg)
+	if err != nil || v == nil {
+		return node, err
+	}
+	return node, json.Unmarshal(data, v)
+}
+
+// Sends a query to another node and waits for the answer.
+// The query is identified by msg.ReplyTo, a new ID is used if it is not set.
+func (s *Server) queryNode(ctx context.Context, node string, msg clusterMessage) (json.RawMessage, error) {
+	if msg.ReplyTo == "" {
+		msg.ReplyTo = uuid.NewString()
+	}
+	msg.Kind = clusterQueryRoom
+
+	replies := make(chan clusterMessage, 1)
+	s.queries.Store(msg.ReplyTo, replies)
+	defer s.queries.Delete(msg.ReplyTo)
+
+	if err := s.publish(node, msg); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(ClusterQueryTimeout)
+	defer timeout.Stop()
+
+	select {
+	case reply := <-replies:
+		if reply.Error != "" {
+			return nil, clusterQueryError(reply.Error)
+		}
+		return reply.Data, nil
+	case <-timeout.C:
+		return nil, apperrors.ErrNodeTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Answer of a node to a query sent to the whole cluster.
+type nodeAnswer struct {
+	data json.RawMessage
+	err  error
+}
+
+// Decodes the answer into v, or returns the error the node answered with.
+func (a nodeAnswer) decode(v any) error {
+	if a.err != nil {
+		return a.err
+	}
+	return json.Unmarshal(a.data, v)
+}
+
+// Sends a query to every other node of the cluster at once and waits for their answers, by node.
+func (s *Server) queryNodes(ctx context.Context, msg clusterMessage) map[string]nodeAnswer {
+	nodes, err := s.directory.Nodes(ctx)
+	if err != nil {
+		s.logger.Error("Failed to list cluster nodes", "error", err)
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	answers := make(map[string]nodeAnswer, len(nodes))
+	for _, node := range nodes {
+		if node == s.nodeID {
+			continue
+		}
+		wg.Go(func() {
+			data, err := s.queryNode(ctx, node, msg)
+			mu.Lock()
+			answers[node] = nodeAnswer{data: data, err: err}
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return answers
+}
+
+// Maps an error code answered by another node back to its error.
+func clusterQueryError(code string) error {
+	for _, err := range clusterQueryErrors {
+		if err.Error() == code {
+			return err
+		}
+	}
+	return errors.New(code)
+}
+
+// Answers a query of another node about this node or a room held here.
+func (s *Server) answerQuery(msg clusterMessage) {
+	var data any
+	var err error
+
+	switch msg.Query {
+	case queryRooms:
+		data = s.localRooms()
+	case queryAdminRooms:
+		data = s.localRoomDetails()
+	case queryKick:
+		err = s.kickClient(msg.ClientID, msg.Reason)
+	case queryClose:
+		err = s.closeRoom(msg.RoomID, msg.Reason)
+	case queryLog:
+		data, err = s.roomEvents(msg.RoomID)
+	case queryAnnounce:
+		var message string
+		if err = json.Unmarshal(msg.Data, &message); err == nil {
+			data = s.announce(message)
+		}
+	default:
+		room := s.openRoom(msg.RoomID)
+		switch {
+		case room == nil:
+			err = apperrors.ErrRoomNotFound
+		case msg.Query == queryRoom:
+			data = room.publicRoom()
+		case msg.Query == queryRecord:
+			data, err = room.GetGameRecord()
+		case msg.Query == queryFeed:
+			data, err = s.serveFeed(room, msg)
+		case msg.Query == queryDetails:
+			data = room.Details(true)
+		}
+	}
+
+	reply := clusterMessage{Kind: clusterReply, ReplyTo: msg.ReplyTo}
+	if err == nil {
+		reply.Data, err = json.Marshal(data)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	if err := s.publish(msg.Node, reply); err != nil {
+		s.logger.Error("Failed to answer cluster query", "room_id", msg.RoomID, "node", msg.Node, "error", err)
+	}
+}
+
+// Subscribes to the spectator feed of a room on behalf of another node and relays its events to that node.
+// Returns the backlog of the subscription.
+func (s *Server) serveFeed(room *GameRoom, msg clusterMessage) ([]FeedEvent, error) {
+	sub, err := room.SubscribeFeed(msg.LastEventID)
+	if err != nil {
+		return nil, err
+	}
+	s.servedFeeds.Store(msg.ReplyTo, func() { room.UnsubscribeFeed(sub) })
+
+	go func() {
+		defer s.servedFeeds.Delete(msg.ReplyTo)
+
+		for event := range sub.Events {
+			data, _ := json.Marshal(event)
+			if s.publish(msg.Node, clusterMessage{Kind: clusterFeedEvent, ReplyTo: msg.ReplyTo, Data: data}) != nil {
+				// Dropped like a subscriber that fell behind, the client resumes from its last event
+				room.UnsubscribeFeed(sub)
+				break
+			}
+		}
+
+		if err := s.publish(msg.Node, clusterMessage{Kind: clusterFeedEnd, ReplyTo: msg.ReplyTo}); err != nil {
+			s.logger.Error("Failed to end remote feed", "room_id", room.ID, "node", msg.Node, "error", err)
+		}
+	}()
+
+	return sub.Backlog, nil
+}
+
+// Handles the answers to queries and the messages about feeds followed across nodes.
+func (s *Server) handleQueryMessage(msg clusterMessage) {
+	switch msg.Kind {
+	case clusterReply:
+		if replies, ok := s.queries.Load(msg.ReplyTo); ok {
+			select {
+			case replies <- msg:
+			default:
+			}
+		}
+	case clusterFeedEvent, clusterFeedEnd:
+		feed, ok := s.remoteFeeds.Load(msg.ReplyTo)
+		if !ok {
+			// Nobody follows the feed here anymore, for example after the query timed out
+			if msg.Kind == clusterFeedEvent {
+				s.publish(msg.Node, clusterMessage{Kind: clusterUnsubscribe, ReplyTo: msg.ReplyTo})
+			}
+			return
+		}
+
+		var event FeedEvent
+		if msg.Kind == clusterFeedEnd || json.Unmarshal(msg.Data, &event) != nil || !feed.push(event) {
+			// The feed ended or the client fell behind, clients reconnect with their last event ID
+			s.remoteFeeds.Delete(msg.ReplyTo)
+			feed.close()
+			if msg.Kind != clusterFeedEnd {
+				s.publish(msg.Node, clusterMessage{Kind: clusterUnsubscribe, ReplyTo: msg.ReplyTo})
+			}
+		}
+	case clusterUnsubscribe:
+		if unsubscribe, ok := s.servedFeeds.Load(msg.ReplyTo); ok {
+			unsubscribe()
+		}
+	}
+}
+
+// Spectator feed of a room of another node, fed by the events the owner relays.
+type remoteFeed struct {
+	mu     sync.Mutex
+	events chan FeedEvent
+	closed bool
+}
+
+// Queues an event for the client. Returns false if the feed is closed or the client fell behind.
+func (f *remoteFeed) push(event FeedEvent) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	select {
+	case f.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *remoteFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.events)
+	}
+}
+
+// Returns the spectator view of the room.
+func (gr *GameRoom) publicRoom() (view PublicRoom) {
+	gr.do(func() {
+		view = PublicRoom{RoomSummary: gr.summary(), GameState: gr.publicGameState()}
+	})
+	return view
+}
@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+const (
+	RequestCacheSize = 64              // Number of recent requests remembered for each client
+	RequestCacheTTL  = 5 * time.Minute // Time the requests of a disconnected client are remembered, so retries after reconnecting are still detected
+)
+
+// Errors caused by the state of the server or the cluster rather than by the request.
+// Requests failing with them are not remembered, so that retrying them can succeed.
+// They are returned before the request changes anything, so handling a retry again is safe.
+var transientErrors = []error{
+	apperrors.ErrBusFull,
+	apperrors.ErrNodeTimeout,
+	apperrors.ErrIDGenerationFailed,
+	apperrors.ErrPeerBufferFull,
+}
+
+// A request a client has sent, with the responses it produced.
+type cachedRequest struct {
+	done      bool
+	responses []cachedResponse
+}
+
+// An encoded response, with its type for the metrics when it is sent again.
+type cachedResponse struct {
+	msgType MsgType
+	data    []byte
+}
+
+// Recent requests of a client by their request ID, so that retried requests are not executed twice.
+// The oldest request is forgotten once the cache is full.
+type requestCache struct {
+	mu       sync.Mutex
+	requests map[string]*cachedRequest
+	order    []string // Request IDs, oldest first
+	lastUsed time.Time
+}
+
+func newRequestCache() *requestCache {
+	return &requestCache{
+		requests: make(map[string]*cachedRequest),
+		lastUsed: time.Now(),
+	}
+}
+
+// Registers a request before it is handled.
+// If the request was seen before, returns its responses and whether it has been handled yet.
+func (c *requestCache) begin(requestID string) (responses []cachedResponse, done bool, duplicate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastUsed = time.Now()
+	if req, ok := c.requests[requestID]; ok {
+		return req.responses, req.done, true
+	}
+
+	if len(c.order) >= RequestCacheSize {
+		delete(c.requests, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.requests[requestID] = &cachedRequest{}
+	c.order = append(c.order, requestID)
+	return nil, false, false
+}
+
+// Adds a response to a request.
+func (c *requestCache) record(requestID string, msgType MsgType, msg []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req, ok := c.requests[requestID]; ok {
+		req.responses = append(req.responses, cachedResponse{msgType: msgType, data: msg})
+	}
+}
+
+// Marks a request as handled, duplicates received from now on get its responses back.
+func (c *requestCache) finish(requestID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req, ok := c.requests[requestID]; ok {
+		req.done = true
+	}
+}
+
+// Forgets a request, used when it is answered by another node.
+func (c *requestCache) forget(requestID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.requests[requestID]; ok {
+		delete(c.requests, requestID)
+		c.order = slices.DeleteFunc(c.order, func(id string) bool { return id == requestID })
+	}
+}
+
+// Records the time the client was last seen.
+func (c *requestCache) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastUsed = time.Now()
+}
+
+// Checks if the cache has not been used for longer than the TTL.
+func (c *requestCache) expired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Since(c.lastUsed) > RequestCacheTTL
+}
+
+// Checks if a request failed for a reason that can go away when it is retried.
+func isTransientError(err error) bool {
+	return slices.ContainsFunc(transientErrors, func(transient error) bool {
+		return errors.Is(err, transient)
+	})
+}
+
+// Forgets a request of a client, so that a retry of it is handled again.
+func forgetRequest(peer Peer, requestID string) {
+	if cache := mustLoad[*requestCache](peer.Session(), "requests"); cache != nil && requestID != "" {
+		cache.forget(requestID)
+	}
+}
+
+// Encodes a response and remembers it so that duplicates of the request get it back.
+func rememberResponse(peer Peer, msg OutgoingMessage) []byte {
+	data := msg.encode()
+	if cache := mustLoad[*requestCache](peer.Session(), "requests"); cache != nil && msg.RequestID != "" {
+		cache.record(msg.RequestID, msg.Type, data)
+	}
+	return data
+}
+
+// Sends the response to the request msg answers.
+func respond(peer Peer, msg OutgoingMessage) error {
+	return sendEncoded(peer, msg.Type, rememberResponse(peer, msg))
+}
+
+// Sends the response to the request msg answers without waiting for it to be written.
+func respondAsync(peer Peer, msg OutgoingMessage, callback func(error)) {
+	peer.SendAsync(rememberResponse(peer, msg), countSent(msg.Type, callback))
+}
+
+// Returns the request cache of a client, creating it if the client has not been seen recently.
+func (s *Server) requestCacheFor(clientID string) *requestCache {
+	shard := s.requests.GetSharding(clientID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	cache, ok := shard.Load(clientID)
+	if !ok {
+		cache = newRequestCache()
+		shard.Store(clientID, cache)
+	}
+	return cache
+}
+
+// Forgets the requests of clients that have been disconnected for longer than the TTL.
+func (s *Server) pruneRequestCaches() {
+	// The map is locked while ranging, so the caches are deleted afterwards
+	expired := make([]string, 0)
+	s.requests.Range(func(clientID string, cache *requestCache) bool {
+		if _, connected := s.clients.Load(clientID); !connected && cache.expired() {
+			expired = append(expired, clientID)
+		}
+		return true
+	})
+
+	for _, clientID := range expired {
+		s.requests.Delete(clientID)
+	}
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
+)
+
+func TestRequestCacheBegin(t *testing.T) {
+	tests := []struct {
+		name          string
+		seen          int // Requests begun before the lookup, named req-0 to req-N
+		lookup        string
+		wantDuplicate bool
+	}{
+		{name: "new request", seen: 3, lookup: "other"},
+		{name: "retried request", seen: 3, lookup: "req-1", wantDuplicate: true},
+		{name: "oldest request of a full cache", seen: RequestCacheSize, lookup: "req-0", wantDuplicate: true},
+		{name: "evicted request", seen: RequestCacheSize + 1, lookup: "req-0"},
+		{name: "oldest request kept after eviction", seen: RequestCacheSize + 1, lookup: "req-1", wantDuplicate: true},
+		{name: "newest request", seen: 2 * RequestCacheSize, lookup: fmt.Sprintf("req-%d", 2*RequestCacheSize-1), wantDuplicate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newRequestCache()
+			for i := range tt.seen {
+				cache.begin(fmt.Sprintf("req-%d", i))
+			}
+
+			if _, _, duplicate := cache.begin(tt.lookup); duplicate != tt.wantDuplicate {
+				t.Fatalf("got duplicate %v, want %v", duplicate, tt.wantDuplicate)
+			}
+			if len(cache.order) > RequestCacheSize || len(cache.requests) != len(cache.order) {
+				t.Fatalf("cache holds %d requests in order and %d by ID, want at most %d", len(cache.order), len(cache.requests), RequestCacheSize)
+			}
+		})
+	}
+}
+
+func TestRequestCacheReplaysResponses(t *testing.T) {
+	cache := newRequestCache()
+	cache.begin("req")
+	cache.record("req", MsgTypeAck, []byte("first"))
+
+	if responses, done, _ := cache.begin("req"); done || len(responses) != 1 {
+		t.Fatalf("got %d responses and done %v for a request being handled", len(responses), done)
+	}
+
+	cache.record("req", MsgTypeAck, []byte("second"))
+	cache.finish("req")
+	responses, done, _ := cache.begin("req")
+	if !done || len(responses) != 2 || string(responses[1].data) != "second" {
+		t.Fatalf("got %d responses and done %v for a handled request", len(responses), done)
+	}
+}
+
+func TestPruneRequestCaches(t *testing.T) {
+	tests := []struct {
+		name       string
+		connected  bool
+		lastUsed   time.Duration // Time since the cache was last used
+		wantPruned bool
+	}{
+		{name: "recently disconnected", lastUsed: RequestCacheTTL / 2},
+		{name: "disconnected past the TTL", lastUsed: RequestCacheTTL + time.Second, wantPruned: true},
+		{name: "connected past the TTL", connected: true, lastUsed: RequestCacheTTL + time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			clientID := "disconnected"
+			if tt.connected {
+				clientID = connectTestClient(t, srv).id
+			}
+
+			cache := srv.requestCacheFor(clientID)
+			cache.mu.Lock()
+			cache.lastUsed = time.Now().Add(-tt.lastUsed)
+			cache.mu.Unlock()
+
+			srv.pruneRequestCaches()
+			if _, kept := srv.requests.Load(clientID); kept == tt.wantPruned {
+				t.Fatalf("got cache kept %v, want %v", kept, !tt.wantPruned)
+			}
+		})
+	}
+}
+
+func TestTransientErrorsAreNotRemembered(t *testing.T) {
+	tests := []struct {
+		err          error
+		wantReplayed bool
+	}{
+		{err: apperrors.ErrBusFull},
+		{err: apperrors.ErrNodeTimeout},
+		{err: apperrors.ErrIDGenerationFailed},
+		{err: apperrors.ErrIllegalMove, wantReplayed: true},
+		{err: apperrors.ErrRoomNotFound, wantReplayed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			client := connectTestClient(t, srv)
+			cache := mustLoad[*requestCache](client.peer.Session(), "requests")
+
+			cache.begin("req")
+			srv.writeError(client.peer, tt.err, "req")
+			cache.finish("req")
+			client.expectError(tt.err.Error())
+
+			if _, _, duplicate := cache.begin("req"); duplicate != tt.wantReplayed {
+				t.Fatalf("got retry answered from the cache %v, want %v", duplicate, tt.wantReplayed)
+			}
+		})
+	}
+}
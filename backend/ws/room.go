@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"log/slog"
+	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,28 +13,43 @@ import (
 	"github.com/CDavidSV/online-flip-flop/config"
 	"github.com/CDavidSV/online-flip-flop/games"
 	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
-	"github.com/CDavidSV/online-flip-flop/internal/types"
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
 	"github.com/google/uuid"
-	"github.com/lxzan/gws"
 )
 
 type Status string
+type ColorPolicy string
+type EndReason string
 
 // Represents a player in the room.
 type PlayerSlot struct {
-	ID           string           `json:"id"`
+	ID           string           `json:"id,omitempty"` // Left out for spectators
 	Username     string           `json:"username"`
 	Color        games.PlayerSide `json:"color"`
 	IsAI         bool             `json:"is_ai"`
 	IsActive     bool             `json:"is_active"`
 	wantsRematch bool             `json:"-"`
+
+	// Time at which the opponent can claim the game if this player has not reconnected.
+	AbandonDeadline *time.Time  `json:"abandon_deadline,omitempty"`
+	abandonTimer    *time.Timer `json:"-"`
+	claimTimer      *time.Timer `json:"-"` // Ends the game once the opponent had time to claim it
+}
+
+// A player as shown outside the room. Leaves out the client ID, which lets whoever holds it take the seat.
+type PublicPlayer struct {
+	Username        string           `json:"username"`
+	Color           games.PlayerSide `json:"color"`
+	IsAI            bool             `json:"is_ai"`
+	IsActive        bool             `json:"is_active"`
+	AbandonDeadline *time.Time       `json:"abandon_deadline,omitempty"`
 }
 
 // Holds the client connection and whether they are a spectator or not.
 type ClientConnection struct {
 	ID          string
 	Username    string
-	conn        *gws.Conn
+	conn        Peer
 	isSpectator bool
 }
 
@@ -43,10 +60,50 @@ type GameState struct {
 	Winner      games.PlayerSide         `json:"winner"`
 	Players     []PlayerSlot             `json:"players"`
 	MoveHistory []games.MoveHistoryEntry `json:"move_history"`
+	Series      *SeriesState             `json:"series,omitempty"`
+}
+
+// Game state as shown outside the room, through the REST API and the spectator event stream.
+type PublicGameState struct {
+	GameState
+	Players []PublicPlayer `json:"players"`
+}
+
+// Outcome of the last finished game in a room.
+type GameResult struct {
+	Reason EndReason        `json:"reason"`
+	Winner games.PlayerSide `json:"winner"`
+}
+
+// Summary of a room for listings.
+type RoomSummary struct {
+	ID         string         `json:"id"`
+	GameMode   GameMode       `json:"game_mode"`
+	GameType   games.GameType `json:"game_type"`
+	Status     Status         `json:"status"`
+	Players    []PublicPlayer `json:"players"`
+	Spectators int            `json:"spectators"`
+	Series     *SeriesState   `json:"series,omitempty"`
+}
+
+// Response to a resync request.
+type ResyncResult struct {
+	Events    []json.RawMessage `json:"events"`               // Missed events in order, as they were originally sent
+	GameState *GameState        `json:"game_state,omitempty"` // Sent instead of the events when they are no longer buffered
+	Seq       uint64            `json:"seq"`                  // Sequence number of the latest event
+}
+
+// Record of a finished game that can be exported.
+type GameRecord struct {
+	RoomID      string                   `json:"room_id"`
+	GameType    games.GameType           `json:"game_type"`
+	Players     []PublicPlayer           `json:"players"`
+	Result      GameResult               `json:"result"`
+	MoveHistory []games.MoveHistoryEntry `json:"move_history"`
 }
 
 type SavedMessage struct {
-	ClientID string `json:"client_id"`
+	ClientID string `json:"client_id,omitempty"` // Left out for spectators
 	Username string `json:"username"`
 	Message  string `json:"message"`
 }
@@ -58,17 +115,33 @@ type GameRoom struct {
 	GameType          games.GameType
 	gameStarted       bool
 	ai                ai.AI
+	aiDifficulty      ai.AIDifficulty
 	aiThinking        bool
 	aiCancelFunc      context.CancelFunc
 	player1           *PlayerSlot
 	player2           *PlayerSlot
 	conns             map[string]*ClientConnection
+	playerFeed        *roomFeed
+	spectatorFeed     *roomFeed
 	status            Status
 	logger            *slog.Logger
-	mu                sync.RWMutex
 	playerMessages    []SavedMessage
 	spectatorMessages []SavedMessage
 	lastInactiveTime  time.Time
+	settings          *config.Store
+	spectatorDelay    SpectatorDelay
+	spectatorView     spectatorView
+	delayedMoves      []delayedMove
+	series            *SeriesState
+	seriesLength      int
+	colorPolicy       ColorPolicy
+	abandonGrace      time.Duration // Set if the creator of the room chose a grace period, overriding the setting
+	abortTimer        *time.Timer
+	events            []RoomEvent
+	result            *GameResult
+	commands          chan func()
+	stopped           chan struct{}
+	stoppedMu         sync.Mutex
 }
 
 const (
@@ -79,18 +152,37 @@ const (
 	StatusClosed       Status = "closed"              // Game has ended or room is closed (no active players).
 )
 
+const (
+	ColorPolicyAlternate ColorPolicy = "alternate" // Players switch colors on every rematch.
+	ColorPolicyKeep      ColorPolicy = "keep"      // Players keep their colors on rematch.
+	ColorPolicyRandom    ColorPolicy = "random"    // Colors are assigned randomly on rematch.
+)
+
+const (
+	EndReasonNormal    EndReason = "normal"    // A player won by the rules of the game.
+	EndReasonDraw      EndReason = "draw"      // The game ended in a draw.
+	EndReasonForfeit   EndReason = "forfeit"   // A player forfeited the game.
+	EndReasonAbandoned EndReason = "abandoned" // A player disconnected and did not return in time.
+	EndReasonAborted   EndReason = "aborted"   // The game was called off before both players moved, it has no result.
+)
+
 type RoomConfig struct {
-	ID           string
-	GameMode     GameMode
-	AIDifficulty ai.AIDifficulty
-	GameType     games.GameType
-	Logger       *slog.Logger
+	ID             string
+	GameMode       GameMode
+	AIDifficulty   ai.AIDifficulty
+	GameType       games.GameType
+	SpectatorDelay SpectatorDelay
+	SeriesLength   int
+	ColorPolicy    ColorPolicy
+	AbandonGrace   time.Duration // Overrides the abandon_grace_period setting when set
+	Settings       *config.Store // Server settings that can change while the room is open
+	Logger         *slog.Logger
 }
 
 type InitialPlayer struct {
 	ClientID string
 	Username string
-	Conn     *gws.Conn
+	Peer     Peer
 }
 
 // Create and returns a new GameRoom instance with the first player already set up.
@@ -105,15 +197,31 @@ func NewGameRoom(config RoomConfig, player InitialPlayer) (*GameRoom, error) {
 		Game:              game,
 		GameMode:          config.GameMode,
 		GameType:          config.GameType,
+		aiDifficulty:      config.AIDifficulty,
 		gameStarted:       false,
 		conns:             make(map[string]*ClientConnection),
+		playerFeed:        newRoomFeed(config.ID),
+		spectatorFeed:     newRoomFeed(config.ID),
 		status:            StatusWaiting,
-		logger:            config.Logger,
+		logger:            config.Logger.With("room_id", config.ID),
 		playerMessages:    []SavedMessage{},
 		spectatorMessages: []SavedMessage{},
 		lastInactiveTime:  time.Now(),
+		settings:          config.Settings,
+		spectatorDelay:    config.SpectatorDelay,
+		series:            newSeries(config.SeriesLength),
+		seriesLength:      config.SeriesLength,
+		colorPolicy:       config.ColorPolicy,
+		abandonGrace:      config.AbandonGrace,
+	}
+	room.spectatorFeed.view = room.publicPayload
+
+	if room.colorPolicy == "" {
+		room.colorPolicy = ColorPolicyAlternate
 	}
 
+	room.spectatorView = room.currentSpectatorView()
+
 	// Set up first player
 	room.player1 = &PlayerSlot{
 		ID:           player.ClientID,
@@ -127,7 +235,7 @@ func NewGameRoom(config RoomConfig, player InitialPlayer) (*GameRoom, error) {
 	room.conns[player.ClientID] = &ClientConnection{
 		ID:          player.ClientID,
 		Username:    player.Username,
-		conn:        player.Conn,
+		conn:        player.Peer,
 		isSpectator: false,
 	}
 
@@ -150,7 +258,7 @@ func NewGameRoom(config RoomConfig, player InitialPlayer) (*GameRoom, error) {
 			room.ai = gameAI
 		}
 
-		// AI player will always be player 2 with black pieces
+		// AI player will always be player 2, starting with black pieces
 		room.player2 = &PlayerSlot{
 			ID:           uuid.New().String(),
 			Username:     gameAI.Name(),
@@ -161,40 +269,136 @@ func NewGameRoom(config RoomConfig, player InitialPlayer) (*GameRoom, error) {
 		}
 	}
 
+	created := roomCreatedEvent{
+		RoomID:         room.ID,
+		GameMode:       room.GameMode,
+		GameType:       room.GameType,
+		AIDifficulty:   config.AIDifficulty,
+		SpectatorDelay: room.spectatorDelay,
+		SeriesLength:   room.seriesLength,
+		ColorPolicy:    room.colorPolicy,
+		AbandonGrace:   room.abandonGrace,
+		Players:        []PlayerSlot{*room.player1},
+	}
+	if room.player2 != nil {
+		created.Players = append(created.Players, *room.player2)
+	}
+	room.recordEvent(EventRoomCreated, player.ClientID, created)
+	room.start()
+
 	return room, nil
 }
 
-// Broadcasts a game update to all connections in the room, skipping the connection with skipID if provided.
-// A lock is required before calling this method.
-func (gr *GameRoom) broadcastGameUpdate(action MsgType, payload any, skipID *string) {
-	msg := NewMessage(action, payload, "") // RequestID is empty since it's a broadcast
+// Sends an encoded message to the connections in the room that match the spectator flag, skipping the connection with skipID if provided.
+// Must be called from the room goroutine.
+func (gr *GameRoom) broadcast(msgType MsgType, msg []byte, spectators bool, skipID *string) {
+	start := time.Now()
+	defer func() {
+		metrics.BroadcastLatency.Observe(time.Since(start).Seconds())
+	}()
 
-	// Use GWS broadcaster to only encode the message once
-	b := gws.NewBroadcaster(gws.OpcodeText, msg)
-	defer b.Close()
+	// Use a broadcaster to only encode the message once per encoding
+	b := newBroadcaster(msgType, msg)
 
 	for id, connData := range gr.conns {
 		// Skip only the connections with the clientID that matches the provided skipID
-		if skipID != nil && id == *skipID {
+		if connData.isSpectator != spectators || (skipID != nil && id == *skipID) {
 			continue
 		}
 
 		err := b.Broadcast(connData.conn)
 		if err != nil {
-			gr.logger.Error("Failed to broadcast message", "error", err)
+			gr.logger.Error("Failed to broadcast message", "client_id", id, "error", err)
 		}
 	}
 }
 
+// Records an event in the feed of the players or the spectators and sends it to them.
+// Returns the sequence number of the event. Must be called from the room goroutine.
+func (gr *GameRoom) broadcastEvent(spectators bool, action MsgType, payload any, skipID *string) uint64 {
+	event := gr.feedFor(spectators).publish(action, payload)
+	gr.broadcast(event.Type, event.Data, spectators, skipID)
+	return event.ID
+}
+
+// Sends an event to both players.
+// Must be called from the room goroutine.
+func (gr *GameRoom) broadcastToPlayers(action MsgType, payload any, skipID *string) uint64 {
+	return gr.broadcastEvent(false, action, payload, skipID)
+}
+
+// Sends an event to all spectators.
+// Must be called from the room goroutine.
+func (gr *GameRoom) broadcastToSpectators(action MsgType, payload any, skipID *string) uint64 {
+	return gr.broadcastEvent(true, action, payload, skipID)
+}
+
+// Broadcasts a game update to all connections in the room, skipping the connection with skipID if provided.
+// Players receive the update right away, while spectators receive moves after the room's spectator delay.
+// Returns the sequence number of the update in the players feed. Must be called from the room goroutine.
+func (gr *GameRoom) broadcastGameUpdate(action MsgType, payload any, skipID *string) uint64 {
+	seq := gr.broadcastToPlayers(action, payload, skipID)
+
+	if gr.spectatorDelay.enabled() {
+		switch action {
+		case MsgTypeMove:
+			gr.queueSpectatorMove(payload)
+			return seq
+		case MsgTypeGameEnd:
+			// The result is public, so there is nothing left to hide
+			gr.flushSpectatorMoves()
+		}
+	}
+
+	gr.broadcastToSpectators(action, payload, skipID)
+	return seq
+}
+
+// Acknowledges a request, with the sequence number of the event it produced if the client was skipped by its broadcast.
+// Must be called from the room goroutine.
+func (gr *GameRoom) sendAck(clientID, requestID string, seq uint64) {
+	client, ok := gr.conns[clientID]
+	if !ok || requestID == "" {
+		return
+	}
+
+	// The callback runs on the writer of the connection, so the logger is built while on the room goroutine
+	log := gr.requestLogger(clientID, requestID)
+	respondAsync(client.conn, OutgoingMessage{Type: MsgTypeAck, RoomID: gr.ID, RequestID: requestID, Seq: seq}, func(err error) {
+		if err != nil {
+			log.Error("Failed to send acknowledgment", "error", err)
+		}
+	})
+}
+
 // Called when the game ends to update room status and notify connected clients.
-// Requires a Write lock before calling.
-func (gr *GameRoom) endGame(reason string, winner games.PlayerSide) {
+// Must be called from the room goroutine.
+func (gr *GameRoom) endGame(reason EndReason, winner games.PlayerSide) {
 	gr.status = StatusEnded
-	payload := types.JSONMap{"reason": reason}
+	gr.result = &GameResult{Reason: reason, Winner: winner}
+	metrics.GamesFinished.WithLabelValues(string(gr.GameMode), string(reason)).Inc()
+	gr.stopAbandonTimers()
+	gr.stopAbortTimer()
+	payload := GameEnded{Reason: reason}
 	if winner != -1 {
-		payload["winner"] = winner
+		payload.Winner = &winner
 	}
+
+	seriesDecided := false
+	if gr.series != nil && !gr.series.Ended && reason != EndReasonAborted {
+		seriesDecided = gr.series.recordResult(gr.player1, gr.player2, winner)
+		payload.Series = gr.series.snapshot()
+	}
+
 	gr.broadcastGameUpdate(MsgTypeGameEnd, payload, nil)
+	gr.recordEvent(EventGameEnded, "", gameEndedEvent{Reason: reason, Winner: winner})
+
+	if seriesDecided {
+		gr.broadcastGameUpdate(MsgTypeSeriesEnd, SeriesEnded{
+			Winner: gr.series.Winner,
+			Scores: gr.series.snapshot().Scores,
+		}, nil)
+	}
 
 	// If the game mode is single player and the ai is thinking, cancel the computation
 	if gr.GameMode == "singleplayer" && gr.aiThinking {
@@ -204,30 +408,25 @@ func (gr *GameRoom) endGame(reason string, winner games.PlayerSide) {
 	// If the game mode is singleplayer, the ai will request for a rematch
 	if gr.GameMode == "singleplayer" {
 		// Request rematch by the ai (player2) after a short delay
-		go func() {
-			time.Sleep(2 * time.Second)
-
-			gr.mu.RLock()
-			aiPlayerID := gr.player2.ID
-			gr.mu.RUnlock()
-
-			if err := gr.RequestRematch(aiPlayerID); err != nil {
-				gr.logger.Error("AI failed to request rematch", "error", err)
-			}
-		}()
+		aiPlayerID := gr.player2.ID
+		time.AfterFunc(2*time.Second, func() {
+			gr.do(func() {
+				if err := gr.requestRematch(aiPlayerID, ""); err != nil {
+					gr.logger.Error("AI failed to request rematch", "error", err)
+				}
+			})
+		})
 	}
 }
 
 // Called when the game ends to update room status and notify connected clients.
 // This is the public version that can be called from the server.
-func (gr *GameRoom) EndGame(reason string, winner games.PlayerSide) {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
-	gr.endGame(reason, winner)
+func (gr *GameRoom) EndGame(reason EndReason, winner games.PlayerSide) {
+	gr.do(func() { gr.endGame(reason, winner) })
 }
 
 // Constructs and returns the current game state.
-// Requires a Read lock before calling.
+// Must be called from the room goroutine.
 func (gr *GameRoom) gameState() GameState {
 	players := make([]PlayerSlot, 2)
 	if gr.player1 != nil {
@@ -244,11 +443,25 @@ func (gr *GameRoom) gameState() GameState {
 		Status:      gr.status,
 		Winner:      gr.Game.GetWinner(),
 		MoveHistory: gr.Game.GetMoveHistory(),
+		Series:      gr.series.snapshot(),
+	}
+}
+
+// Returns a logger that identifies the client and the request being handled.
+// Must be called from the room goroutine.
+func (gr *GameRoom) requestLogger(clientID, requestID string) *slog.Logger {
+	attrs := []any{"client_id", clientID}
+	if requestID != "" {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	if client, ok := gr.conns[clientID]; ok {
+		attrs = append(attrs, "conn_id", mustLoad[string](client.conn.Session(), "conn_id"))
 	}
+	return gr.logger.With(attrs...)
 }
 
 // Retrieves a player from by their ID.
-// Requires a Read lock before calling.
+// Must be called from the room goroutine.
 func (gr *GameRoom) getPlayer(id string) *PlayerSlot {
 	if gr.player1 != nil && gr.player1.ID == id {
 		return gr.player1
@@ -260,13 +473,13 @@ func (gr *GameRoom) getPlayer(id string) *PlayerSlot {
 }
 
 // Checks if both player slots are inactive (either nil or not active).
-// Requires a Read lock before calling.
+// Must be called from the room goroutine.
 func (gr *GameRoom) playersInactive() bool {
 	return (gr.player1 == nil || !gr.player1.IsActive) && (gr.player2 == nil || !gr.player2.IsActive)
 }
 
 // Checks if both player slots are active.
-// Requires a Read lock before calling.
+// Must be called from the room goroutine.
 func (gr *GameRoom) playersActive() bool {
 	return (gr.player1 != nil && gr.player1.IsActive) && (gr.player2 != nil && gr.player2.IsActive)
 }
@@ -286,10 +499,14 @@ func (gr *GameRoom) validateActionStatus() error {
 
 // Called when a client requests to join a room.
 // Returns whether the client is a spectator.
-func (gr *GameRoom) EnterRoom(id string, conn *gws.Conn, username string) (isSpectator bool, err error) {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+func (gr *GameRoom) EnterRoom(id string, peer Peer, username string) (isSpectator bool, err error) {
+	gr.do(func() { isSpectator, err = gr.enterRoom(id, peer, username) })
+	return isSpectator, err
+}
 
+// Adds a client to the room as a player or a spectator.
+// Must be called from the room goroutine.
+func (gr *GameRoom) enterRoom(id string, peer Peer, username string) (bool, error) {
 	if gr.status == StatusClosed {
 		return false, apperrors.ErrRoomClosed
 	}
@@ -303,26 +520,35 @@ func (gr *GameRoom) EnterRoom(id string, conn *gws.Conn, username string) (isSpe
 
 		// Reconnecting player
 		player.IsActive = true
+		gr.stopAbandonTimer(player)
+		gr.recordEvent(EventPlayerRejoined, id, nil)
 
 		clientConnection := &ClientConnection{
 			ID:          id,
-			conn:        conn,
+			conn:        peer,
 			isSpectator: false,
 			Username:    player.Username,
 		}
 		gr.conns[id] = clientConnection
 
-		// If the game has already started, update the status to ongoing again
-		if gr.playersActive() && gr.gameStarted {
+		// If the game has already started, update the status to ongoing again.
+		// A finished game keeps its status so that players can still request a rematch.
+		switch {
+		case gr.status == StatusEnded:
+		case gr.playersActive() && gr.gameStarted:
 			gr.status = StatusOngoing
-		} else {
+		default:
 			gr.status = StatusWaitingStart
 		}
 
-		// Notify player rejoined
-		gr.broadcastGameUpdate(MsgPlayerRejoined, types.JSONMap{
-			"player_id":  id,
-			"game_state": gr.gameState(),
+		// Notify player rejoined, spectators get the delayed game state
+		gr.broadcastToPlayers(MsgPlayerRejoined, PlayerRejoined{
+			PlayerID:  id,
+			GameState: gr.gameState(),
+		}, &id)
+		gr.broadcastToSpectators(MsgPlayerRejoined, PlayerRejoined{
+			PlayerID:  id,
+			GameState: gr.spectatorGameState(),
 		}, &id)
 
 		return false, nil
@@ -339,7 +565,7 @@ func (gr *GameRoom) EnterRoom(id string, conn *gws.Conn, username string) (isSpe
 
 	clientConnection := &ClientConnection{
 		ID:          id,
-		conn:        conn,
+		conn:        peer,
 		isSpectator: false,
 		Username:    username,
 	}
@@ -371,11 +597,13 @@ func (gr *GameRoom) EnterRoom(id string, conn *gws.Conn, username string) (isSpe
 			gr.status = StatusWaitingStart
 		}
 
+		gr.recordEvent(EventPlayerJoined, id, playerJoinedEvent{Username: username, Color: color})
 		return false, nil
 	}
 
 	// Join as spectator
 	clientConnection.isSpectator = true
+	gr.recordEvent(EventSpectatorJoined, id, spectatorJoinedEvent{Username: username})
 
 	return true, nil
 }
@@ -383,9 +611,15 @@ func (gr *GameRoom) EnterRoom(id string, conn *gws.Conn, username string) (isSpe
 // Called when a client leaves the room.
 // If all players leave, the room then is closed after a timeout period if no one rejoins.
 func (gr *GameRoom) LeaveRoom(id string) {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+	gr.do(func() { gr.leaveRoom(id) })
+}
 
+// Removes a client from the room.
+// Must be called from the room goroutine.
+func (gr *GameRoom) leaveRoom(id string) {
+	if _, ok := gr.conns[id]; ok {
+		gr.recordEvent(EventLeft, id, nil)
+	}
 	delete(gr.conns, id)
 
 	// Spectators do not count as players
@@ -395,16 +629,23 @@ func (gr *GameRoom) LeaveRoom(id string) {
 
 		if player.wantsRematch {
 			player.wantsRematch = false
-			gr.broadcastGameUpdate(MsgTypeRematchCancelled, types.JSONMap{
-				"player_id": id,
-			}, &id)
+			gr.broadcastGameUpdate(MsgTypeRematchCancelled, PlayerUpdate{PlayerID: id}, &id)
 		}
 
-		gr.broadcastGameUpdate(MsgPlayerLeftRoom, types.JSONMap{
-			"player_id": id,
-		}, nil)
+		leftPayload := PlayerLeft{PlayerID: id}
+
+		// Give the player some time to reconnect before the opponent can claim the game
+		if gr.GameMode == "multiplayer" && gr.gameInProgress() {
+			gr.startAbandonTimer(player)
+			leftPayload.AbandonDeadline = player.AbandonDeadline
+			leftPayload.GracePeriod = int(gr.abandonGracePeriod().Seconds())
+		}
+
+		gr.broadcastGameUpdate(MsgPlayerLeftRoom, leftPayload, nil)
 
-		gr.status = StatusWaiting
+		if gr.status != StatusEnded && gr.status != StatusClosed {
+			gr.status = StatusWaiting
+		}
 
 		if gr.playersInactive() {
 			gr.lastInactiveTime = time.Now()
@@ -414,13 +655,21 @@ func (gr *GameRoom) LeaveRoom(id string) {
 
 // Starts the game if both player slots are filled and active.
 // Returns a boolean indicating if the game was started.
-func (gr *GameRoom) StartGame() bool {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+func (gr *GameRoom) StartGame() (started bool) {
+	gr.do(func() { started = gr.startGame() })
+	return started
+}
 
+// Must be called from the room goroutine.
+func (gr *GameRoom) startGame() bool {
 	if gr.playersActive() && !gr.gameStarted && gr.status == StatusWaitingStart {
 		gr.gameStarted = true
 		gr.status = StatusOngoing
+		gr.resetSpectatorView()
+		gr.updateAbortTimer()
+		gr.recordGameStarted()
+		gr.logGameStarted(gr.logger)
+		metrics.GamesStarted.WithLabelValues(string(gr.GameMode), string(gr.GameType)).Inc()
 		gr.broadcastGameUpdate(MsgTypeGameStart, gr.gameState(), nil)
 		return true
 	}
@@ -428,12 +677,23 @@ func (gr *GameRoom) StartGame() bool {
 	return false
 }
 
+// Logs the starting board of a game at debug level, the board is only drawn if the line is written.
+// Must be called from the room goroutine.
+func (gr *GameRoom) logGameStarted(log *slog.Logger) {
+	if log.Enabled(context.Background(), slog.LevelDebug) {
+		log.Debug("Game started", "turn", gr.Game.CurrentTurn(), "board", gr.Game.DrawBoard())
+	}
+}
+
 // Handles a move made by a player.
 // Registers and validates the move according to game rules and broadcasts the update.
-func (gr *GameRoom) HandleMove(clientID, requestID string, movePayload json.RawMessage) (games.PlayerSide, error) {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+func (gr *GameRoom) HandleMove(clientID, requestID string, movePayload json.RawMessage) (color games.PlayerSide, err error) {
+	gr.do(func() { color, err = gr.handleMove(clientID, requestID, movePayload) })
+	return color, err
+}
 
+// Must be called from the room goroutine.
+func (gr *GameRoom) handleMove(clientID, requestID string, movePayload json.RawMessage) (games.PlayerSide, error) {
 	if err := gr.validateActionStatus(); err != nil {
 		return -1, err
 	}
@@ -453,28 +713,27 @@ func (gr *GameRoom) HandleMove(clientID, requestID string, movePayload json.RawM
 	if err != nil {
 		return -1, err
 	}
+	gr.recordEvent(EventMove, clientID, moveEvent{Color: player.Color, Move: movePayload})
+	metrics.MovesProcessed.WithLabelValues(string(gr.GameMode)).Inc()
 
-	if clientConn, ok := gr.conns[clientID]; ok {
-		ackMsg := NewMessage(MsgTypeAck, nil, requestID)
-		clientConn.conn.WriteAsync(gws.OpcodeText, ackMsg, func(err error) {
-			if err != nil {
-				gr.logger.Error("Failed to send move acknowledgment", "error", err)
-			}
-		})
-	}
+	log := gr.requestLogger(clientID, requestID)
+	log.Debug("Move applied", "color", player.Color, "move", movePayload, "board", gr.Game.GetBoardString())
 
-	gr.broadcastGameUpdate(MsgTypeMove, types.JSONMap{
-		"player_id": clientID,
-		"color":     player.Color,
-		"move":      movePayload,
-		"board":     gr.Game.GetBoardString(),
+	seq := gr.broadcastGameUpdate(MsgTypeMove, MoveMade{
+		PlayerID: clientID,
+		Color:    player.Color,
+		Move:     movePayload,
+		Board:    gr.Game.GetBoardString(),
 	}, &clientID)
+	gr.sendAck(clientID, requestID, seq)
+
+	gr.updateAbortTimer()
 
 	if gr.Game.IsGameEnded() {
 		if gr.Game.GetWinner() == -1 {
-			gr.endGame("draw", -1)
+			gr.endGame(EndReasonDraw, -1)
 		} else {
-			gr.endGame("normal", gr.Game.GetWinner())
+			gr.endGame(EndReasonNormal, gr.Game.GetWinner())
 		}
 		return player.Color, nil
 	}
@@ -482,17 +741,20 @@ func (gr *GameRoom) HandleMove(clientID, requestID string, movePayload json.RawM
 	// Trigger AI move if in singleplayer mode
 	if gr.GameMode == "singleplayer" {
 		gr.ai.GetGame().ApplyMove(movePayload) // Update AI's internal game state
-		go gr.handleAIMove()
+		gr.scheduleAIMove(log)
 	}
 
 	return player.Color, nil
 }
 
 // Handles a forfeit request from a player, awarding victory to the opponent, and closing the room.
-func (gr *GameRoom) HandleForfeit(clientID string) error {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+func (gr *GameRoom) HandleForfeit(clientID string) (err error) {
+	gr.do(func() { err = gr.handleForfeit(clientID) })
+	return err
+}
 
+// Must be called from the room goroutine.
+func (gr *GameRoom) handleForfeit(clientID string) error {
 	if err := gr.validateActionStatus(); err != nil {
 		return err
 	}
@@ -510,20 +772,30 @@ func (gr *GameRoom) HandleForfeit(clientID string) error {
 		opponentColor = games.COLOR_WHITE
 	}
 
-	gr.endGame("forfeit", opponentColor)
+	gr.recordEvent(EventForfeit, clientID, nil)
+	gr.endGame(EndReasonForfeit, opponentColor)
 	return nil
 }
 
-// Handles the AI move in singleplayer mode.
-func (gr *GameRoom) handleAIMove() {
-	// Move delay to make the move feel like the AI is thinking
-	time.Sleep(time.Second * time.Duration(config.AIMoveDelay))
-
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+// Gives the turn to the AI in singleplayer mode. The AI starts searching after a delay to make it feel like it is thinking.
+// The logger carries the IDs of the request that gave the turn to the AI. Must be called from the room goroutine.
+func (gr *GameRoom) scheduleAIMove(log *slog.Logger) {
+	game := gr.Game
+	time.AfterFunc(gr.settings.Load().AIMoveDelay, func() {
+		gr.do(func() { gr.startAIMove(game, log) })
+	})
+}
 
+// Starts searching for the AI move in the given game, unless it has been replaced by a rematch since.
+// The search runs on its own goroutine and hands the move back to the room once it is found.
+// Must be called from the room goroutine.
+func (gr *GameRoom) startAIMove(game games.Game, log *slog.Logger) {
 	if gr.ai == nil {
-		gr.logger.Error("AI not initialized for this game")
+		log.Error("AI not initialized for this game")
+		return
+	}
+
+	if gr.Game != game {
 		return
 	}
 
@@ -538,65 +810,88 @@ func (gr *GameRoom) handleAIMove() {
 	}
 
 	// Create context
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.AIThinkTimeout)*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), gr.settings.Load().AIThinkTimeout)
 	gr.aiThinking = true
 	gr.aiCancelFunc = cancel
 
+	gameAI, color := gr.ai, aiPlayer.Color
 	go func() {
-		defer func() {
-			cancel()
-			gr.mu.Lock()
-			gr.aiThinking = false
-			gr.aiCancelFunc = nil
-			gr.mu.Unlock()
-		}()
-
-		bestMove, err := gr.ai.GetBestMove(ctx, aiPlayer.Color)
-		if err != nil {
-			gr.logger.Error("AI failed to find a move", "error", err)
-			return
-		}
+		defer cancel()
 
-		gr.mu.Lock()
-		defer gr.mu.Unlock()
+		thinkStart := time.Now()
+		bestMove, err := gameAI.GetBestMove(ctx, color)
+		thinkTime := time.Since(thinkStart)
+		metrics.AIThinkTime.WithLabelValues(string(gr.aiDifficulty)).Observe(thinkTime.Seconds())
 
-		// If bestMove is nil, it means the AI could not find a valid move so it will forfeit
-		if bestMove == nil {
-			gr.endGame("forfeit", gr.player1.Color)
-			return
-		}
+		gr.do(func() { gr.finishAIMove(game, bestMove, err, thinkTime, log) })
+	}()
+}
 
-		err = gr.Game.ApplyMove(bestMove)
-		if err != nil {
-			gr.logger.Error("AI move application failed", "error", err)
-			return
-		}
+// Applies the move found by the AI search. If the AI could not find a valid move, it forfeits.
+// Must be called from the room goroutine.
+func (gr *GameRoom) finishAIMove(game games.Game, bestMove json.RawMessage, err error, thinkTime time.Duration, log *slog.Logger) {
+	// A rematch may have replaced the game while the AI was thinking
+	if gr.Game != game {
+		return
+	}
+	gr.aiThinking = false
+	gr.aiCancelFunc = nil
 
-		// Apply the move for the AI's internal game state
-		gr.ai.GetGame().ApplyMove(bestMove)
+	if err != nil {
+		log.Error("AI failed to find a move", "error", err, "think_time", thinkTime)
+		return
+	}
 
-		gr.broadcastGameUpdate(MsgTypeMove, types.JSONMap{
-			"player_id": aiPlayer.ID,
-			"color":     aiPlayer.Color,
-			"move":      bestMove,
-			"board":     gr.Game.GetBoardString(),
-		}, nil)
+	if err := gr.validateActionStatus(); err != nil {
+		return
+	}
 
-		if gr.Game.IsGameEnded() {
-			if gr.Game.GetWinner() == -1 {
-				gr.endGame("draw", -1)
-			} else {
-				gr.endGame("normal", gr.Game.GetWinner())
-			}
+	aiPlayer := gr.player2
+
+	// If bestMove is nil, it means the AI could not find a valid move so it will forfeit
+	if bestMove == nil {
+		log.Debug("AI found no valid move and forfeits", "think_time", thinkTime)
+		gr.recordEvent(EventForfeit, aiPlayer.ID, nil)
+		gr.endGame(EndReasonForfeit, gr.player1.Color)
+		return
+	}
+
+	err = gr.Game.ApplyMove(bestMove)
+	if err != nil {
+		log.Error("AI move application failed", "error", err)
+		return
+	}
+	log.Debug("AI move applied", "color", aiPlayer.Color, "move", bestMove, "board", gr.Game.GetBoardString(), "think_time", thinkTime)
+	gr.recordEvent(EventMove, aiPlayer.ID, moveEvent{Color: aiPlayer.Color, Move: bestMove})
+	metrics.MovesProcessed.WithLabelValues(string(gr.GameMode)).Inc()
+
+	// Apply the move for the AI's internal game state
+	gr.ai.GetGame().ApplyMove(bestMove)
+
+	gr.broadcastGameUpdate(MsgTypeMove, MoveMade{
+		PlayerID: aiPlayer.ID,
+		Color:    aiPlayer.Color,
+		Move:     bestMove,
+		Board:    gr.Game.GetBoardString(),
+	}, nil)
+
+	if gr.Game.IsGameEnded() {
+		if gr.Game.GetWinner() == -1 {
+			gr.endGame(EndReasonDraw, -1)
+		} else {
+			gr.endGame(EndReasonNormal, gr.Game.GetWinner())
 		}
-	}()
+	}
 }
 
 // Handles a chat message sent by a client and broadcasts it to other clients.
-func (gr *GameRoom) HandleChatMessage(clientID, requestID, message string) error {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
+func (gr *GameRoom) HandleChatMessage(clientID, requestID, message string) (err error) {
+	gr.do(func() { err = gr.handleChatMessage(clientID, requestID, message) })
+	return err
+}
 
+// Must be called from the room goroutine.
+func (gr *GameRoom) handleChatMessage(clientID, requestID, message string) error {
 	if gr.status == StatusClosed {
 		return apperrors.ErrRoomClosed
 	}
@@ -611,54 +906,88 @@ func (gr *GameRoom) HandleChatMessage(clientID, requestID, message string) error
 		return nil
 	}
 
-	msg := NewMessage(MsgTypeChat, types.JSONMap{
-		"client_id": clientID,
-		"username":  sender.Username,
-		"message":   message,
-	}, "")
+	// Broadcast message to spectators or players
+	seq := gr.broadcastEvent(sender.isSpectator, MsgTypeChat, SavedMessage{
+		ClientID: clientID,
+		Username: sender.Username,
+		Message:  message,
+	}, &clientID)
+	gr.sendAck(clientID, requestID, seq)
 
-	ackMsg := NewMessage(MsgTypeAck, nil, requestID)
-	sender.conn.WriteAsync(gws.OpcodeText, ackMsg, func(err error) {
-		if err != nil {
-			gr.logger.Error("Failed to send move acknowledgment", "error", err)
-		}
-	})
+	// Save message to history
+	gr.saveChatMessage(SavedMessage{
+		ClientID: clientID,
+		Username: sender.Username,
+		Message:  message,
+	}, sender.isSpectator)
+	gr.recordEvent(EventChat, clientID, chatEvent{Message: message, Spectator: sender.isSpectator})
 
-	b := gws.NewBroadcaster(gws.OpcodeText, msg)
-	defer b.Close()
+	return nil
+}
 
-	// Broadcast message to spectators or players
-	for _, clientConn := range gr.conns {
-		if clientConn.ID == clientID {
-			continue
-		}
+// Adds a chat message to the player or spectator history.
+// Must be called from the room goroutine.
+func (gr *GameRoom) saveChatMessage(msg SavedMessage, spectator bool) {
+	if spectator {
+		gr.spectatorMessages = append(gr.spectatorMessages, msg)
+	} else {
+		gr.playerMessages = append(gr.playerMessages, msg)
+	}
+}
 
-		if clientConn.isSpectator == sender.isSpectator {
-			if err := b.Broadcast(clientConn.conn); err != nil {
-				gr.logger.Error("Failed to broadcast chat message", "error", err)
-			}
+// Replaces the game with a new one, keeping the players and their colors.
+// Must be called from the room goroutine.
+func (gr *GameRoom) resetGame() error {
+	newGame, err := games.NewGame(gr.GameType)
+	if err != nil {
+		return err
+	}
+	gr.Game = newGame
+	gr.result = nil
 
-			// Same message to history
-			msg := SavedMessage{
-				ClientID: clientID,
-				Username: sender.Username,
-				Message:  message,
-			}
-			if !sender.isSpectator {
-				gr.playerMessages = append(gr.playerMessages, msg)
-			} else {
-				gr.spectatorMessages = append(gr.spectatorMessages, msg)
-			}
+	// The AI keeps its own copy of the game to search on
+	if gr.ai != nil {
+		aiGameCopy, err := games.NewGame(gr.GameType)
+		if err != nil {
+			return err
 		}
+		gr.ai.SetGame(aiGameCopy)
 	}
 
+	gr.resetSpectatorView()
 	return nil
 }
 
-func (gr *GameRoom) RequestRematch(clientID string) error {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+// Updates the player colors for the next game according to the room's color policy.
+// Games in a series always alternate colors. Must be called from the room goroutine.
+func (gr *GameRoom) assignRematchColors() {
+	policy := gr.colorPolicy
+	if gr.series != nil {
+		policy = ColorPolicyAlternate
+	}
+
+	swap := false
+	switch policy {
+	case ColorPolicyAlternate:
+		swap = true
+	case ColorPolicyRandom:
+		swap = rand.Intn(2) == 0
+	}
+
+	if swap {
+		gr.player1.Color, gr.player2.Color = gr.player2.Color, gr.player1.Color
+	}
+}
 
+// Handles a rematch request from a player, starting the next game once both players have asked for it.
+// The request is acknowledged if requestID is set.
+func (gr *GameRoom) RequestRematch(clientID, requestID string) (err error) {
+	gr.do(func() { err = gr.requestRematch(clientID, requestID) })
+	return err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) requestRematch(clientID, requestID string) error {
 	if gr.status != StatusEnded {
 		return apperrors.ErrGameNotEnded
 	}
@@ -669,126 +998,288 @@ func (gr *GameRoom) RequestRematch(clientID string) error {
 	}
 
 	player.wantsRematch = true
+	gr.recordEvent(EventRematchRequested, clientID, nil)
 
 	// If both players want a rematch, reset the game
 	if gr.player1.wantsRematch && gr.player2.wantsRematch {
-		newGame, err := games.NewGame(gr.GameType)
-		if err != nil {
+		if err := gr.resetGame(); err != nil {
 			return err
 		}
-		gr.Game = newGame
 
-		if gr.ai != nil {
-			gr.ai.SetGame(newGame)
+		// Within a series, the rematch starts the next game.
+		// Once the series is decided, a rematch starts a new series.
+		if gr.series != nil {
+			if gr.series.Ended {
+				gr.series = newSeries(gr.seriesLength)
+			} else {
+				gr.series.nextGame()
+			}
 		}
+		gr.assignRematchColors()
 
 		gr.status = StatusOngoing
 		gr.player1.wantsRematch = false
 		gr.player2.wantsRematch = false
-		gr.broadcastGameUpdate(MsgTypeGameStart, gr.gameState(), nil)
+		gr.updateAbortTimer()
+		gr.recordGameStarted()
+		gr.logGameStarted(gr.requestLogger(clientID, requestID))
+		metrics.GamesStarted.WithLabelValues(string(gr.GameMode), string(gr.GameType)).Inc()
+		seq := gr.broadcastGameUpdate(MsgTypeGameStart, gr.gameState(), nil)
+		gr.sendAck(clientID, requestID, seq)
+
+		// The AI may have the first move after switching colors
+		if gr.ai != nil && gr.player2.Color == gr.Game.CurrentTurn() {
+			gr.scheduleAIMove(gr.requestLogger(clientID, ""))
+		}
 	} else {
 		// Notify that a rematch has been requested
-		gr.broadcastGameUpdate(MsgTypeRematchRequested, types.JSONMap{
-			"player_id": clientID,
-		}, &clientID)
+		seq := gr.broadcastGameUpdate(MsgTypeRematchRequested, PlayerUpdate{PlayerID: clientID}, &clientID)
+		gr.sendAck(clientID, requestID, seq)
 	}
 
 	return nil
 }
 
-func (gr *GameRoom) CancelRematchRequest(clientID string) error {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+// Withdraws a rematch request from a player. The request is acknowledged if requestID is set.
+func (gr *GameRoom) CancelRematchRequest(clientID, requestID string) (err error) {
+	gr.do(func() { err = gr.cancelRematchRequest(clientID, requestID) })
+	return err
+}
 
+// Must be called from the room goroutine.
+func (gr *GameRoom) cancelRematchRequest(clientID, requestID string) error {
 	player := gr.getPlayer(clientID)
 	if player == nil {
 		return apperrors.ErrUnauthorizedAction
 	}
 	player.wantsRematch = false
+	gr.recordEvent(EventRematchCancelled, clientID, nil)
 
-	gr.broadcastGameUpdate(MsgTypeRematchCancelled, types.JSONMap{
-		"player_id": clientID,
-	}, &clientID)
+	seq := gr.broadcastGameUpdate(MsgTypeRematchCancelled, PlayerUpdate{PlayerID: clientID}, &clientID)
+	gr.sendAck(clientID, requestID, seq)
 	return nil
 }
 
 // Get current game state.
-func (gr *GameRoom) GetGameState() GameState {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
+func (gr *GameRoom) GetGameState() (state GameState) {
+	gr.do(func() { state = gr.gameState() })
+	return state
+}
+
+// Get the game state as seen by the given client, with the sequence number of the last event it reflects.
+// Spectators receive the delayed state if the room has a spectator delay.
+func (gr *GameRoom) GetGameStateFor(clientID string) (state GameState, seq uint64) {
+	gr.do(func() { state, seq = gr.gameStateFor(clientID) })
+	return state, seq
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) gameStateFor(clientID string) (GameState, uint64) {
+	if conn, ok := gr.conns[clientID]; ok && conn.isSpectator {
+		return gr.spectatorGameState(), gr.spectatorFeed.seq
+	}
+	return gr.gameState(), gr.playerFeed.seq
+}
+
+// Returns the events a client missed after lastSeq, as they were originally sent.
+// If they are no longer buffered, the current game state is returned instead.
+func (gr *GameRoom) Resync(clientID string, lastSeq uint64) (result ResyncResult, err error) {
+	gr.do(func() { result, err = gr.resync(clientID, lastSeq) })
+	return result, err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) resync(clientID string, lastSeq uint64) (ResyncResult, error) {
+	client, ok := gr.conns[clientID]
+	if !ok {
+		return ResyncResult{}, apperrors.ErrClientNotFound
+	}
 
-	return gr.gameState()
+	feed := gr.feedFor(client.isSpectator)
+	result := ResyncResult{Events: []json.RawMessage{}, Seq: feed.seq}
+
+	missed, ok := feed.since(lastSeq)
+	if !ok {
+		state := gr.gameState()
+		if client.isSpectator {
+			state = gr.spectatorGameState()
+		}
+		result.GameState = &state
+		return result, nil
+	}
+
+	for _, event := range missed {
+		result.Events = append(result.Events, event.Data)
+	}
+	return result, nil
 }
 
 // Returns a copy of all connections in the room.
-func (gr *GameRoom) GetPlayerConnections() []*gws.Conn {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
+func (gr *GameRoom) GetPlayerConnections() (conns []Peer) {
+	gr.do(func() { conns = gr.playerConnections() })
+	return conns
+}
 
-	conns := make([]*gws.Conn, 0, len(gr.conns))
+// Must be called from the room goroutine.
+func (gr *GameRoom) playerConnections() []Peer {
+	conns := make([]Peer, 0, len(gr.conns))
 	for _, connData := range gr.conns {
 		conns = append(conns, connData.conn)
 	}
 	return conns
 }
 
-// Checks if the room is closed.
-func (gr *GameRoom) IsClosed() bool {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
+// Returns the players that have taken a slot in the room, without their client IDs.
+// Must be called from the room goroutine.
+func (gr *GameRoom) publicPlayers() []PublicPlayer {
+	players := make([]PublicPlayer, 0, 2)
+	for _, player := range []*PlayerSlot{gr.player1, gr.player2} {
+		if player != nil {
+			players = append(players, player.public())
+		}
+	}
+	return players
+}
+
+// Returns the player as shown outside the room.
+func (p *PlayerSlot) public() PublicPlayer {
+	return PublicPlayer{
+		Username:        p.Username,
+		Color:           p.Color,
+		IsAI:            p.IsAI,
+		IsActive:        p.IsActive,
+		AbandonDeadline: p.AbandonDeadline,
+	}
+}
 
-	return gr.status == StatusClosed
+// Returns a summary of the room.
+func (gr *GameRoom) Summary() (summary RoomSummary) {
+	gr.do(func() { summary = gr.summary() })
+	return summary
 }
 
-func (gr *GameRoom) GetMessages(spectator bool) []SavedMessage {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
+// Must be called from the room goroutine.
+func (gr *GameRoom) summary() RoomSummary {
+	spectators := 0
+	for _, conn := range gr.conns {
+		if conn.isSpectator {
+			spectators++
+		}
+	}
 
-	var messages []SavedMessage
+	return RoomSummary{
+		ID:         gr.ID,
+		GameMode:   gr.GameMode,
+		GameType:   gr.GameType,
+		Status:     gr.status,
+		Players:    gr.publicPlayers(),
+		Spectators: spectators,
+		Series:     gr.series.snapshot(),
+	}
+}
+
+// Returns the record of the last finished game in the room.
+func (gr *GameRoom) GetGameRecord() (record GameRecord, err error) {
+	gr.do(func() { record, err = gr.gameRecord() })
+	return record, err
+}
+
+// Must be called from the room goroutine.
+func (gr *GameRoom) gameRecord() (GameRecord, error) {
+	if gr.result == nil {
+		if gr.gameStarted {
+			return GameRecord{}, apperrors.ErrGameNotEnded
+		}
+		return GameRecord{}, apperrors.ErrGameNotStarted
+	}
+
+	return GameRecord{
+		RoomID:      gr.ID,
+		GameType:    gr.GameType,
+		Players:     gr.publicPlayers(),
+		Result:      *gr.result,
+		MoveHistory: gr.Game.GetMoveHistory(),
+	}, nil
+}
+
+// Checks if a game is currently being played in the room.
+func (gr *GameRoom) IsGameInProgress() (inProgress bool) {
+	gr.do(func() { inProgress = gr.gameInProgress() })
+	return inProgress
+}
+
+// Checks if the room is closed.
+func (gr *GameRoom) IsClosed() (closed bool) {
+	gr.do(func() { closed = gr.status == StatusClosed })
+	return closed
+}
+
+func (gr *GameRoom) GetMessages(spectator bool) (messages []SavedMessage) {
+	gr.do(func() { messages = gr.chatHistory(spectator) })
+	return messages
+}
+
+// Returns the last 100 chat messages of the players or the spectators.
+// Must be called from the room goroutine.
+func (gr *GameRoom) chatHistory(spectator bool) []SavedMessage {
+	messages := gr.playerMessages
 	if spectator {
 		messages = gr.spectatorMessages
-	} else {
-		messages = gr.playerMessages
 	}
 
 	// Return the last 100 elements of the array
 	if len(messages) > 100 {
-		return messages[len(messages)-100:]
+		messages = messages[len(messages)-100:]
 	}
 
+	// Spectators do not get client IDs, which let whoever holds them take the seat
+	if spectator {
+		messages = slices.Clone(messages)
+		for i := range messages {
+			messages[i].ClientID = ""
+		}
+	}
 	return messages
 }
 
-func (gr *GameRoom) GetCurrentInactiveTime() time.Time {
-	gr.mu.RLock()
-	defer gr.mu.RUnlock()
-
-	return gr.lastInactiveTime
+func (gr *GameRoom) GetCurrentInactiveTime() (inactiveTime time.Time) {
+	gr.do(func() { inactiveTime = gr.lastInactiveTime })
+	return inactiveTime
 }
 
 func (gr *GameRoom) CloseRoomIfInactive() {
-	gr.mu.Lock()
-	defer gr.mu.Unlock()
+	gr.do(gr.closeRoomIfInactive)
+}
 
+// Closes the room if no player has been connected for longer than the inactivity timeout.
+// Must be called from the room goroutine.
+func (gr *GameRoom) closeRoomIfInactive() {
 	if gr.status == StatusClosed {
 		return
 	}
 
 	// Check if the room has been inactive for longer by the timeout
-	if (time.Since(gr.lastInactiveTime) > time.Duration(config.RoomInactiveTimeout)*time.Second) && gr.playersInactive() {
-		// Close the room
-		gr.status = StatusClosed
+	if (time.Since(gr.lastInactiveTime) > gr.settings.Load().RoomInactiveTimeout) && gr.playersInactive() {
+		gr.closeRoom("room_inactive")
+	}
+}
 
-		// Cancel any ongoing AI computation
-		if gr.aiThinking {
-			gr.cancelAIComputation()
-		}
+// Closes the room and kicks out every client that is still connected.
+// Must be called from the room goroutine.
+func (gr *GameRoom) closeRoom(reason string) {
+	gr.status = StatusClosed
+	gr.recordEvent(EventRoomClosed, "", roomClosedEvent{Reason: reason})
 
-		// Kick out all remaining spectators
-		gr.broadcastGameUpdate(MsgTypeKicked, types.JSONMap{
-			"reason": "room_inactive",
-		}, nil)
+	// Cancel any ongoing AI computation
+	if gr.aiThinking {
+		gr.cancelAIComputation()
 	}
+	gr.stopAbandonTimers()
+	gr.stopAbortTimer()
+
+	// Kick out all remaining clients
+	gr.broadcastGameUpdate(MsgTypeKicked, Kicked{Reason: reason}, nil)
+	gr.spectatorFeed.close()
 }
 
 func (gr *GameRoom) cancelAIComputation() {
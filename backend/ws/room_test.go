@@ -0,0 +1,315 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/games"
+)
+
+// Returns the first valid move of the player whose turn it is.
+func firstValidMove(t *testing.T, game *games.FlipFlop) games.BaseMove {
+	t.Helper()
+
+	player := game.Player1
+	if player.Color != game.CurrentTurn() {
+		player = game.Player2
+	}
+
+	moves, _ := game.GetValidMoves(player)
+	if len(moves) == 0 {
+		t.Fatalf("no valid moves on board %s", game.GetBoardString())
+	}
+	from, to := moves[0].From, moves[0].To
+	return games.BaseMove{From: from.String(int(game.Type)), To: to.String(int(game.Type))}
+}
+
+// Applies a move to the copy of the game the test keeps.
+func applyMove(t *testing.T, game *games.FlipFlop, move any) {
+	t.Helper()
+
+	data, err := json.Marshal(move)
+	if err != nil {
+		t.Fatalf("encoding move: %v", err)
+	}
+	if err := game.ApplyMove(data); err != nil {
+		t.Fatalf("applying move %s: %v", data, err)
+	}
+}
+
+func TestSingleplayerGameAgainstAI(t *testing.T) {
+	srv := newTestServer(t, nil)
+	player := connectTestClient(t, srv)
+	player.send(MsgTypeCreateRoom, "", CreateRoom{
+		GameType:   games.TYPE_FLIPFLOP3x3,
+		GameMode:   "singleplayer",
+		Difficulty: "easy",
+		Username:   "player",
+	})
+
+	// Singleplayer games start before the room is confirmed to have been created
+	var state GameState
+	roomID := player.expectPayload(MsgTypeGameStart, &state).RoomID
+	player.expect(MsgTypeRoomCreated)
+	color := colorOf(t, state, player.id)
+
+	// The test plays the first valid move every turn and follows the AI moves on its own copy of the game
+	mirror := games.NewFlipFlopGame(games.FlipFlop3x3)
+	var ended GameEnded
+	aiMoves := 0
+	for turns := 0; ; turns++ {
+		if turns > 500 {
+			t.Fatal("game did not end")
+		}
+
+		if !mirror.IsGameEnded() && mirror.CurrentTurn() == color {
+			move := firstValidMove(t, mirror)
+			applyMove(t, mirror, move)
+			player.send(MsgTypeMove, roomID, move)
+		}
+
+		msg := player.next()
+		if msg.RoomID != roomID {
+			t.Fatalf("got %s for room %q, want %q", msg.Type, msg.RoomID, roomID)
+		}
+
+		switch msg.Type {
+		case MsgTypeMove:
+			var made MoveMade
+			json.Unmarshal(msg.Payload, &made)
+			if made.PlayerID == player.id {
+				t.Fatal("got own move back, it should only be acknowledged")
+			}
+			applyMove(t, mirror, made.Move)
+			if made.Board != mirror.GetBoardString() {
+				t.Fatalf("got board %s after AI move, want %s", made.Board, mirror.GetBoardString())
+			}
+			aiMoves++
+		case MsgTypeError:
+			t.Fatalf("got error %s", msg.Payload)
+		}
+
+		if msg.Type == MsgTypeGameEnd {
+			json.Unmarshal(msg.Payload, &ended)
+			break
+		}
+	}
+
+	if aiMoves == 0 {
+		t.Fatal("the AI never moved")
+	}
+	if !mirror.IsGameEnded() {
+		t.Fatalf("server ended the game with %q, but it is still going on board %s", ended.Reason, mirror.GetBoardString())
+	}
+	winner := games.PlayerSide(-1)
+	if ended.Winner != nil {
+		winner = *ended.Winner
+	}
+	if winner != mirror.GetWinner() {
+		t.Fatalf("got winner %d, want %d", winner, mirror.GetWinner())
+	}
+
+	// The AI asks for a rematch, which starts a new game once the player accepts
+	player.expect(MsgTypeRematchRequested)
+	player.send(MsgTypeRematch, roomID, nil)
+
+	var rematch GameState
+	player.expectPayload(MsgTypeGameStart, &rematch)
+	if rematch.Status != StatusOngoing || len(rematch.MoveHistory) != 0 {
+		t.Fatalf("got rematch in status %q with %d moves, want a new ongoing game", rematch.Status, len(rematch.MoveHistory))
+	}
+}
+
+func TestPublicRoomViewsLeaveOutClientIDs(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+
+	// A series room, so the series scores show up in every view as well
+	roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host", SeriesLength: 3})
+	guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+	var state GameState
+	host.expectPayload(MsgTypeGameStart, &state)
+
+	// Follows the feed from its first event, so the start of the game is in the backlog
+	first := uint64(0)
+	sub, unsubscribe, err := srv.SubscribeRoomFeed(context.Background(), roomID, &first)
+	if err != nil {
+		t.Fatalf("following feed: %v", err)
+	}
+	defer unsubscribe()
+
+	spectator := connectTestClient(t, srv)
+	spectator.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "spectator"})
+	joined := spectator.expect(MsgTypeJoinedRoom)
+
+	// Every kind of event spectators get: a move, a chat message, a player leaving and rejoining, the end and a rematch request
+	mover := host
+	if colorOf(t, state, guest.id) == games.COLOR_WHITE {
+		mover = guest
+	}
+	mover.send(MsgTypeMove, roomID, firstValidMove(t, games.NewFlipFlopGame(games.FlipFlop3x3)))
+	mover.expect(MsgTypeAck)
+	other := connectTestClient(t, srv)
+	other.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "other"})
+	other.expect(MsgTypeJoinedRoom)
+	other.send(MsgTypeSendMessage, roomID, ChatMessage{Content: "hello"})
+	other.expect(MsgTypeAck)
+	srv.Disconnect(guest.peer, nil)
+	rejoined := &testClient{t: t, srv: srv, peer: NewMemoryPeer(guest.id, 256), id: guest.id}
+	if err := srv.Connect(rejoined.peer); err != nil {
+		t.Fatalf("reconnecting guest: %v", err)
+	}
+	rejoined.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID})
+	host.expect(MsgPlayerRejoined)
+	host.send(MsgTypeForfeit, roomID, nil)
+	host.expect(MsgTypeGameEnd)
+	host.send(MsgTypeRematch, roomID, nil)
+	host.expect(MsgTypeAck)
+
+	// The live events, up to the rematch request for spectators and up to the end for the feed, which leaves out rematches
+	var spectated, followed []any
+	for msg := joined; msg.Type != MsgTypeRematchRequested; {
+		msg = spectator.next()
+		spectated = append(spectated, msg)
+	}
+	for _, event := range sub.Backlog {
+		followed = append(followed, json.RawMessage(event.Data))
+	}
+	for event := range sub.Events {
+		followed = append(followed, json.RawMessage(event.Data))
+		if event.Type == MsgTypeGameEnd {
+			break
+		}
+	}
+
+	room, err := srv.FindRoom(context.Background(), roomID)
+	if err != nil {
+		t.Fatalf("finding room: %v", err)
+	}
+	record, err := srv.FindGameRecord(context.Background(), roomID)
+	if err != nil {
+		t.Fatalf("exporting game record: %v", err)
+	}
+
+	views := map[string]any{
+		"room list":      srv.ListRooms(context.Background()),
+		"room":           room,
+		"game record":    record,
+		"feed":           followed,
+		"spectator join": joined,
+		"spectator feed": spectated,
+	}
+	for name, view := range views {
+		data, _ := json.Marshal(view)
+		if !strings.Contains(string(data), "guest") && name != "spectator feed" {
+			t.Fatalf("%s does not list the players: %s", name, data)
+		}
+		if !strings.Contains(string(data), `"scores"`) && name != "game record" {
+			t.Fatalf("%s does not include the series: %s", name, data)
+		}
+		for _, id := range []string{host.id, guest.id, other.id} {
+			if strings.Contains(string(data), id) {
+				t.Fatalf("%s exposes client ID %s: %s", name, id, data)
+			}
+		}
+	}
+}
+
+// Writer that can be read while rooms write to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStartingBoardIsLoggedByTheRoom(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		t.Run(level.String(), func(t *testing.T) {
+			var logs syncBuffer
+			logLevel := new(slog.LevelVar)
+			logLevel.Set(level)
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: logLevel}))
+
+			srv := NewGameServer(config.NewStore(config.Default(), nil, logger), logger)
+			startTestServer(t, srv)
+			roomID, _ := startMultiplayerGame(t, connectTestClient(t, srv), connectTestClient(t, srv))
+
+			var started map[string]any
+			for line := range strings.SplitSeq(logs.String(), "\n") {
+				if strings.Contains(line, `"msg":"Game started"`) {
+					json.Unmarshal([]byte(line), &started)
+				}
+			}
+			switch {
+			case level == slog.LevelInfo && started != nil:
+				t.Fatalf("board logged above debug level: %v", started)
+			case level == slog.LevelDebug && (started["room_id"] != roomID || !strings.Contains(fmt.Sprint(started["board"]), "A B C")):
+				t.Fatalf("got log line %v, want the board of room %s", started, roomID)
+			}
+		})
+	}
+}
+
+func TestRematchColorsFollowPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ColorPolicy
+		wantSwap bool
+	}{
+		{name: "default", wantSwap: true},
+		{name: "alternate", policy: ColorPolicyAlternate, wantSwap: true},
+		{name: "keep", policy: ColorPolicyKeep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			host := connectTestClient(t, srv)
+			guest := connectTestClient(t, srv)
+			roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host", ColorPolicy: tt.policy})
+			guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+			var state GameState
+			host.expectPayload(MsgTypeGameStart, &state)
+			guest.expect(MsgTypeGameStart)
+
+			// Both players see the colors of the rematch, over two rematches
+			for range 2 {
+				hostColor := colorOf(t, state, host.id)
+				host.send(MsgTypeForfeit, roomID, nil)
+				host.expect(MsgTypeGameEnd)
+				host.send(MsgTypeRematch, roomID, nil)
+				host.expect(MsgTypeAck)
+				guest.send(MsgTypeRematch, roomID, nil)
+
+				var guestState GameState
+				host.expectPayload(MsgTypeGameStart, &state)
+				guest.expectPayload(MsgTypeGameStart, &guestState)
+				if swapped := colorOf(t, state, host.id) != hostColor; swapped != tt.wantSwap {
+					t.Fatalf("got colors swapped %v, want %v", swapped, tt.wantSwap)
+				}
+				if colorOf(t, guestState, guest.id) == colorOf(t, state, host.id) {
+					t.Fatal("both players have the same color")
+				}
+			}
+		})
+	}
+}
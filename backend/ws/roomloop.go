@@ -0,0 +1,51 @@
+package ws
+
+// Each room runs on its own goroutine, which owns the room state and runs commands one at a time.
+// Client requests, timers and AI searches never touch the state directly, they hand a command to the room instead.
+//
+// The goroutine stops once the room is closed. Closed and replayed rooms can still be read from,
+// so their commands run on the caller, still one at a time.
+
+// Starts the room goroutine.
+func (gr *GameRoom) start() {
+	gr.commands = make(chan func())
+	gr.stopped = make(chan struct{})
+	go gr.run()
+}
+
+// Marks a room that has no goroutine, such as a replayed one.
+func (gr *GameRoom) startStopped() {
+	gr.stopped = make(chan struct{})
+	close(gr.stopped)
+}
+
+// Runs commands until the room is closed.
+func (gr *GameRoom) run() {
+	defer close(gr.stopped)
+
+	for cmd := range gr.commands {
+		cmd()
+		if gr.status == StatusClosed {
+			return
+		}
+	}
+}
+
+// Runs a command on the room goroutine and waits for it to finish.
+// Must not be called from the room goroutine.
+func (gr *GameRoom) do(cmd func()) {
+	done := make(chan struct{})
+	run := func() {
+		defer close(done)
+		cmd()
+	}
+
+	select {
+	case gr.commands <- run:
+		<-done
+	case <-gr.stopped:
+		gr.stoppedMu.Lock()
+		defer gr.stoppedMu.Unlock()
+		cmd()
+	}
+}
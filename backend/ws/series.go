@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"github.com/CDavidSV/online-flip-flop/games"
+)
+
+// Tracks the running score of a best-of-N match series.
+// Players are identified by their seat, the order in which they are listed in the room, as their colors alternate
+// between games and their client IDs are not shown outside the room.
+type SeriesState struct {
+	Length     int        `json:"length"`           // Maximum number of games in the series
+	GameNumber int        `json:"game_number"`      // Number of the current game, starting at 1
+	Scores     [2]float64 `json:"scores"`           // Points by seat, a draw gives each player half a point
+	Ended      bool       `json:"ended"`            // Whether the series has been decided
+	Winner     *int       `json:"winner,omitempty"` // Seat of the player that won the series, not set if tied
+
+	gamesRecorded int
+}
+
+// Returns a new series of the given length, or nil if the length does not describe a series.
+func newSeries(length int) *SeriesState {
+	if length <= 1 {
+		return nil
+	}
+
+	return &SeriesState{
+		Length:     length,
+		GameNumber: 1,
+	}
+}
+
+// Returns a copy of the series that is safe to send outside the room goroutine.
+func (s *SeriesState) snapshot() *SeriesState {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	if s.Winner != nil {
+		winner := *s.Winner
+		c.Winner = &winner
+	}
+	return &c
+}
+
+// Moves the series on to the next game. A game without a result is replayed under the same number.
+func (s *SeriesState) nextGame() {
+	s.GameNumber = s.gamesRecorded + 1
+}
+
+// Adds the result of a finished game to the score.
+// Returns true if this result decided the series.
+func (s *SeriesState) recordResult(player1, player2 *PlayerSlot, winner games.PlayerSide) bool {
+	for seat, player := range []*PlayerSlot{player1, player2} {
+		switch {
+		case winner == -1:
+			s.Scores[seat] += 0.5
+		case winner == player.Color:
+			s.Scores[seat] += 1
+		}
+	}
+
+	// A player clinches the series once they have more than half of the available points
+	s.gamesRecorded++
+	clinchScore := float64(s.Length) / 2
+	p1Score, p2Score := s.Scores[0], s.Scores[1]
+	if p1Score <= clinchScore && p2Score <= clinchScore && s.gamesRecorded < s.Length {
+		return false
+	}
+
+	s.Ended = true
+	if p1Score != p2Score {
+		seat := 0
+		if p2Score > p1Score {
+			seat = 1
+		}
+		s.Winner = &seat
+	}
+	return true
+}
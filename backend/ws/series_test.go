@@ -0,0 +1,87 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+)
+
+func TestSeriesRecordResult(t *testing.T) {
+	const draw games.PlayerSide = -1
+	white, black := games.COLOR_WHITE, games.COLOR_BLACK
+
+	tests := []struct {
+		name       string
+		length     int
+		winners    []games.PlayerSide // Winning color of each game, player1 plays white
+		wantScores [2]float64
+		wantEnded  bool
+		wantWinner int // Seat of the series winner, -1 if none
+	}{
+		{name: "undecided", length: 3, winners: []games.PlayerSide{white, black}, wantScores: [2]float64{1, 1}, wantWinner: -1},
+		{name: "clinched before the last game", length: 3, winners: []games.PlayerSide{white, white}, wantScores: [2]float64{2, 0}, wantEnded: true, wantWinner: 0},
+		{name: "won by the second player", length: 3, winners: []games.PlayerSide{black, white, black}, wantScores: [2]float64{1, 2}, wantEnded: true, wantWinner: 1},
+		{name: "draws score half a point", length: 3, winners: []games.PlayerSide{draw, draw}, wantScores: [2]float64{1, 1}, wantWinner: -1},
+		{name: "half the points do not clinch", length: 5, winners: []games.PlayerSide{white, white, draw}, wantScores: [2]float64{2.5, 0.5}, wantWinner: -1},
+		{name: "clinched after a draw", length: 5, winners: []games.PlayerSide{white, white, draw, white}, wantScores: [2]float64{3.5, 0.5}, wantEnded: true, wantWinner: 0},
+		{name: "tied after every game", length: 3, winners: []games.PlayerSide{white, draw, black}, wantScores: [2]float64{1.5, 1.5}, wantEnded: true, wantWinner: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := newSeries(tt.length)
+			player1 := &PlayerSlot{ID: "player1", Color: white}
+			player2 := &PlayerSlot{ID: "player2", Color: black}
+
+			for i, winner := range tt.winners {
+				last := i == len(tt.winners)-1
+				if decided := series.recordResult(player1, player2, winner); decided != (last && tt.wantEnded) {
+					t.Fatalf("game %d: got decided %v", i+1, decided)
+				}
+			}
+
+			if series.Scores != tt.wantScores || series.Ended != tt.wantEnded {
+				t.Fatalf("got scores %v and ended %v, want %v and %v", series.Scores, series.Ended, tt.wantScores, tt.wantEnded)
+			}
+			winner := -1
+			if series.Winner != nil {
+				winner = *series.Winner
+			}
+			if winner != tt.wantWinner {
+				t.Fatalf("got winner %d, want %d", winner, tt.wantWinner)
+			}
+		})
+	}
+}
+
+func TestSeriesRematchesAlternateColors(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+
+	// Players would keep their colors outside of a series
+	roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host", SeriesLength: 3, ColorPolicy: ColorPolicyKeep})
+	guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+	var state GameState
+	host.expectPayload(MsgTypeGameStart, &state)
+
+	// The host loses the first two games, deciding the series, and the rematch after that starts a new one
+	wantGames := []int{2, 1, 2}
+	for _, wantGame := range wantGames {
+		hostColor := colorOf(t, state, host.id)
+
+		host.send(MsgTypeForfeit, roomID, nil)
+		host.expect(MsgTypeGameEnd)
+		host.send(MsgTypeRematch, roomID, nil)
+		host.expect(MsgTypeAck)
+		guest.send(MsgTypeRematch, roomID, nil)
+		host.expectPayload(MsgTypeGameStart, &state)
+
+		if got := colorOf(t, state, host.id); got == hostColor {
+			t.Fatalf("host kept color %v in game %d of the series", got, state.Series.GameNumber)
+		}
+		if state.Series.GameNumber != wantGame {
+			t.Fatalf("got game %d of the series, want %d", state.Series.GameNumber, wantGame)
+		}
+	}
+}
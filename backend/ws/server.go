@@ -3,16 +3,19 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"math/rand"
-	"net/http"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/CDavidSV/online-flip-flop/cluster"
 	"github.com/CDavidSV/online-flip-flop/config"
 	"github.com/CDavidSV/online-flip-flop/internal/apperrors"
-	"github.com/CDavidSV/online-flip-flop/internal/types"
+	"github.com/CDavidSV/online-flip-flop/internal/metrics"
 	"github.com/CDavidSV/online-flip-flop/internal/validator"
 	"github.com/google/uuid"
 	"github.com/lxzan/gws"
@@ -23,6 +26,9 @@ type GameMode string
 const (
 	PingInterval = 60 * time.Second // Interval for sending ping messages
 	PingWait     = 10 * time.Second // Wait time for a client to send ping message
+
+	CleanupInterval     = time.Minute         // Interval between runs of the inactive room cleanup job
+	CleanupStallTimeout = 3 * CleanupInterval // Time without a cleanup run after which the job is considered stuck
 )
 
 var validGameModes = []GameMode{
@@ -32,11 +38,30 @@ var validGameModes = []GameMode{
 
 type Server struct {
 	gws.BuiltinEventHandler
+	cfg       *config.Store
 	rooms     *gws.ConcurrentMap[string, *GameRoom]
+	clients   *gws.ConcurrentMap[string, Peer]
+	requests  *gws.ConcurrentMap[string, *requestCache]
+	logs      *roomLogArchive
 	logger    *slog.Logger
 	validator *validator.CustomValidator
 	ctx       context.Context
 	cancel    context.CancelFunc
+	startedAt time.Time
+
+	nodeID    string
+	directory cluster.Directory
+	bus       cluster.Bus
+	busSub    io.Closer
+	remotes   *gws.ConcurrentMap[string, *remotePeer] // Clients of other nodes playing here, by connection ID
+
+	queries     *gws.ConcurrentMap[string, chan clusterMessage] // Queries waiting for the owner of a room to answer, by ID
+	remoteFeeds *gws.ConcurrentMap[string, *remoteFeed]         // Feeds of rooms of other nodes followed from here, by subscription ID
+	servedFeeds *gws.ConcurrentMap[string, func()]              // Feeds of rooms held here followed from other nodes, mapped to their unsubscribe function
+
+	draining      atomic.Bool
+	connections   atomic.Int64
+	lastCleanupAt atomic.Int64 // Unix nanoseconds of the last cleanup job run
 }
 
 // Loads a value from the session storage of a connection.
@@ -48,24 +73,72 @@ func mustLoad[T any](s gws.SessionStorage, key string) (v T) {
 	return
 }
 
-// Builds and sends an error message to a websocket connection.
-func (s *Server) writeError(socket *gws.Conn, err error, requestID string, details ...any) {
-	socket.WriteAsync(gws.OpcodeText, NewErrorMessage(apperrors.New(err, details...), requestID), func(err error) {
+// Builds and sends an error message to a client.
+func (s *Server) writeError(peer Peer, err error, requestID string, details ...any) {
+	log := s.requestLogger(peer, requestID)
+	log.Debug("Request failed", "error", err)
+
+	msg := OutgoingMessage{Type: MsgTypeError, Payload: apperrors.New(err, details...), RequestID: requestID}
+	callback := func(err error) {
 		if err != nil {
-			s.logger.Error("Failed to send error message", "error", err)
+			log.Error("Failed to send error message", "error", err)
 		}
-	})
+	}
+
+	// A retry of a request that failed for a transient reason is handled again instead of getting the error back
+	if isTransientError(err) {
+		forgetRequest(peer, requestID)
+		sendMessage(peer, msg, callback)
+		return
+	}
+	respondAsync(peer, msg, callback)
+}
+
+// Returns the logger of a connection, which identifies the connection and the client.
+func (s *Server) connLogger(peer Peer) *slog.Logger {
+	if logger := mustLoad[*slog.Logger](peer.Session(), "logger"); logger != nil {
+		return logger
+	}
+	return s.logger
 }
 
-// Loads the clientID and GameRoom from the connection's session storage.
-// Returns a boolean value indicating if the client is currently in a room.
-func (s *Server) getClientContext(socket *gws.Conn) (clientID string, room *GameRoom, hasRoom bool) {
-	clientID = mustLoad[string](socket.Session(), "client_id")
-	room = mustLoad[*GameRoom](socket.Session(), "room")
-	hasRoom = room != nil
+// Returns a logger that identifies the connection, the client, the request and the room the client is in, if it is in only one.
+func (s *Server) requestLogger(peer Peer, requestID string) *slog.Logger {
+	logger := s.connLogger(peer)
+	if requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	if rooms := roomsOf(peer); rooms != nil {
+		if roomID := rooms.single(); roomID != "" {
+			logger = logger.With("room_id", roomID)
+		}
+	}
+	return logger
+}
+
+// Returns the logger of a request about the given room.
+func (s *Server) roomRequestLogger(peer Peer, requestID, roomID string) *slog.Logger {
+	return s.connLogger(peer).With("request_id", requestID, "room_id", roomID)
+}
+
+// Loads the clientID from the connection's session storage and finds the room a message is about.
+// Fails if the client is not in the room, or if roomID is empty and the client is in several rooms.
+func (s *Server) getClientContext(peer Peer, roomID string) (clientID string, room *GameRoom, err error) {
+	clientID = mustLoad[string](peer.Session(), "client_id")
+	_, room, _, err = roomsOf(peer).resolve(roomID)
+	if err == nil && room == nil {
+		// Messages about rooms of other nodes are forwarded before they reach the handlers
+		err = apperrors.ErrNotInGame
+	}
 	return
 }
 
+// Checks if a client can enter one more room. Clients without FeatureMultiRoom are kept to a single room.
+func (s *Server) canEnterRoom(peer Peer) bool {
+	version := mustLoad[int](peer.Session(), "protocol_version")
+	return featureSupported(FeatureMultiRoom, version) || roomsOf(peer).count() == 0
+}
+
 // Generate a 4 character room ID.
 func (s *Server) generateRoomID() (string, error) {
 	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -80,96 +153,190 @@ func (s *Server) generateRoomID() (string, error) {
 			builder.WriteByte(charset[rand.Intn(len(charset))])
 		}
 		id = builder.String()
-		if _, exists := s.rooms.Load(id); !exists {
+		if _, exists := s.rooms.Load(id); exists {
+			continue
+		}
+
+		// Other nodes of the cluster may have taken the ID
+		err := s.directory.Claim(s.ctx, id, s.nodeID)
+		if err == nil {
 			return id, nil
 		}
+		if !errors.Is(err, apperrors.ErrRoomTaken) {
+			s.logger.Error("Failed to claim room ID", "room_id", id, "error", err)
+			return id, apperrors.ErrIDGenerationFailed
+		}
 	}
 
 	return id, apperrors.ErrIDGenerationFailed
 }
 
 // Returns a new websocket server instance.
-func NewGameServer(logger *slog.Logger) *Server {
+func NewGameServer(cfg *config.Store, logger *slog.Logger) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
+		cfg:       cfg,
 		rooms:     gws.NewConcurrentMap[string, *GameRoom](),
+		clients:   gws.NewConcurrentMap[string, Peer](),
+		requests:  gws.NewConcurrentMap[string, *requestCache](),
+		logs:      newRoomLogArchive(cfg.Load().ArchivedRoomLogs),
 		logger:    logger,
 		validator: validator.New(),
 		ctx:       ctx,
 		cancel:    cancel,
+		startedAt: time.Now(),
+		nodeID:    uuid.NewString(),
+		directory: cluster.NewMemoryDirectory(),
+		bus:       cluster.NewMemoryBus(),
+		remotes:   gws.NewConcurrentMap[string, *remotePeer](),
+
+		queries:     gws.NewConcurrentMap[string, chan clusterMessage](),
+		remoteFeeds: gws.NewConcurrentMap[string, *remoteFeed](),
+		servedFeeds: gws.NewConcurrentMap[string, func()](),
 	}
 }
 
-func (s *Server) Start() {
+func (s *Server) Start() error {
+	sub, err := s.bus.Subscribe(s.ctx, cluster.NodeTopic(s.nodeID), s.handleClusterMessage)
+	if err != nil {
+		return err
+	}
+	s.busSub = sub
+
+	// Other nodes ask the members of the cluster about their rooms and clients
+	if err := s.directory.Join(s.ctx, s.nodeID); err != nil {
+		sub.Close()
+		return err
+	}
+
 	// Starts the loop to periodically check for inactive rooms to delete.
+	s.lastCleanupAt.Store(time.Now().UnixNano())
 	go s.deleteInactiveRoomsJob()
+	return nil
+}
+
+// Marks the server as draining. Readiness checks fail and new connections are refused,
+// while clients that are already connected can keep playing until the server stops.
+func (s *Server) Drain() {
+	if s.draining.CompareAndSwap(false, true) {
+		s.logger.Info("Draining game server...")
+	}
+}
+
+// Checks if the server can take new traffic.
+// Returns the reason the server is not ready, or nil if it is.
+func (s *Server) Ready() error {
+	switch {
+	case s.draining.Load():
+		return apperrors.ErrServerDraining
+	case s.rooms.Len() > s.cfg.Load().RoomCapacity:
+		return apperrors.ErrServerAtCapacity
+	case time.Since(s.LastCleanup()) > CleanupStallTimeout:
+		return apperrors.ErrCleanupJobStalled
+	}
+	return nil
 }
 
 func (s *Server) Stop() {
 	s.logger.Info("Stopping game server...")
+	if err := s.directory.Leave(s.ctx, s.nodeID); err != nil {
+		s.logger.Error("Failed to leave the cluster", "error", err)
+	}
+	if s.busSub != nil {
+		s.busSub.Close()
+	}
 	s.cancel()
 }
 
-// Websocket handler.
-func WSHandler(server *Server) http.HandlerFunc {
-	upgrader := gws.NewUpgrader(server, &gws.ServerOption{
-		ParallelEnabled:   true,
-		Recovery:          gws.Recovery,
-		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
-	})
+// Retrieves a game room by its ID.
+func (s *Server) GetGameRoom(roomID string) *GameRoom {
+	room, exists := s.rooms.Load(roomID)
+	if !exists {
+		return nil
+	}
 
-	return func(res http.ResponseWriter, req *http.Request) {
-		// Verify that the connecting client is from an allowed origin
-		origin := req.Header.Get("Origin")
-		allowed := false
-		for _, allowedOrigin := range config.AllowedOrigins {
-			if allowedOrigin == "*" || allowedOrigin == origin {
-				allowed = true
-				break
-			}
-		}
+	return room
+}
 
-		if !allowed {
-			server.logger.Warn("WebSocket connection rejected due to invalid origin", "origin", origin)
-			http.Error(res, "Forbidden", http.StatusForbidden)
-			return
+// Returns a summary of every open room held here.
+func (s *Server) localRooms() []RoomSummary {
+	rooms := make([]RoomSummary, 0, s.rooms.Len())
+	s.rooms.Range(func(key string, room *GameRoom) bool {
+		if !room.IsClosed() {
+			rooms = append(rooms, room.Summary())
 		}
+		return true
+	})
+	return rooms
+}
 
-		// Upgrade the connections
-		socket, err := upgrader.Upgrade(res, req)
-		if err != nil {
-			server.logger.Error("WebSocket upgrade failed", "error", err)
-			return
-		}
+// Counts the rooms by status and game mode for the metrics endpoint.
+func (s *Server) CountRooms() map[metrics.RoomLabels]int {
+	counts := make(map[metrics.RoomLabels]int)
+	s.rooms.Range(func(key string, room *GameRoom) bool {
+		summary := room.Summary()
+		counts[metrics.RoomLabels{Status: string(summary.Status), GameMode: string(summary.GameMode)}]++
+		return true
+	})
+	return counts
+}
 
-		// Store client_id from query params in the session
-		clientID := req.URL.Query().Get("client_id")
-		if clientID != "" {
-			// Validate that it's a valid UUID
-			if _, err := uuid.Parse(clientID); err == nil {
-				socket.Session().Store("client_id", clientID)
-			}
+// Returns the number of rooms currently held by the server.
+func (s *Server) RoomCount() int {
+	return s.rooms.Len()
+}
+
+// Returns the number of open websocket connections.
+func (s *Server) ConnectionCount() int64 {
+	return s.connections.Load()
+}
+
+// Returns the time the inactive room cleanup job last ran.
+func (s *Server) LastCleanup() time.Time {
+	return time.Unix(0, s.lastCleanupAt.Load())
+}
+
+// Returns the time the server has been running.
+func (s *Server) Uptime() time.Duration {
+	return time.Since(s.startedAt)
+}
+
+// Returns the event log of a room held here. Logs of recently closed rooms are still available.
+// The log of a room is not available while a game is in progress.
+func (s *Server) roomEvents(roomID string) ([]RoomEvent, error) {
+	if room := s.GetGameRoom(roomID); room != nil {
+		if room.IsGameInProgress() {
+			return nil, apperrors.ErrGameNotEnded
 		}
+		return room.GetEvents(), nil
+	}
 
-		go func() {
-			socket.ReadLoop()
-		}()
+	if events, ok := s.logs.load(roomID); ok {
+		return events, nil
 	}
+
+	return nil, apperrors.ErrRoomNotFound
 }
 
-// Retrieves a game room by its ID.
-func (s *Server) GetGameRoom(roomID string) *GameRoom {
-	room, exists := s.rooms.Load(roomID)
-	if !exists {
-		return nil
+// Rebuilds a room from its event log and returns the state the log leads to.
+func (s *Server) ReplayRoomEvents(ctx context.Context, roomID string) (RoomDetails, error) {
+	events, err := s.FindRoomEvents(ctx, roomID)
+	if err != nil {
+		return RoomDetails{}, err
 	}
 
-	return room
+	room, err := ReplayRoom(events, s.logger)
+	if err != nil {
+		return RoomDetails{}, err
+	}
+	return room.Details(true), nil
 }
 
 // Checks for inactive rooms every minute and deletes them if they have been inactive for longer than the configured timeout.
+// The claims on the other rooms and the membership of the node are renewed, and the requests of clients that left a while ago are forgotten.
+// Each run is recorded so that readiness checks can detect a stuck job.
 func (s *Server) deleteInactiveRoomsJob() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := time.NewTicker(CleanupInterval)
 	defer ticker.Stop()
 
 	roomsToDelete := make([]*GameRoom, 0)
@@ -186,128 +353,210 @@ func (s *Server) deleteInactiveRoomsJob() {
 				room.CloseRoomIfInactive()
 				if room.IsClosed() {
 					s.DeleteGameRoom(room)
+					continue
 				}
+
+				s.renewRoomClaim(room)
 			}
 			roomsToDelete = roomsToDelete[:0]
+			s.renewMembership()
+			s.pruneRequestCaches()
+			s.lastCleanupAt.Store(time.Now().UnixNano())
 		}
 	}
 }
 
-// Deletes a game room from the server and clears the room reference from all connected clients.
+// Renews the claim of the node on a room. Claims expire unless they are renewed, so the rooms of a node that stopped can be taken over.
+// A room another node took over in the meantime is closed, since clients are sent to the other node from then on.
+func (s *Server) renewRoomClaim(room *GameRoom) {
+	err := s.directory.Claim(s.ctx, room.ID, s.nodeID)
+	if errors.Is(err, apperrors.ErrRoomTaken) {
+		s.logger.Error("Room claimed by another node, closing it", "room_id", room.ID)
+		s.closeRoom(room.ID, "room_taken")
+	} else if err != nil {
+		s.logger.Error("Failed to renew room claim", "room_id", room.ID, "error", err)
+	}
+}
+
+// Renews the membership of the node in the cluster, which expires unless it is renewed like room claims.
+func (s *Server) renewMembership() {
+	if err := s.directory.Join(s.ctx, s.nodeID); err != nil {
+		s.logger.Error("Failed to renew cluster membership", "error", err)
+	}
+}
+
+// Deletes a game room from the server and removes it from the rooms of its clients.
 func (s *Server) DeleteGameRoom(room *GameRoom) {
 	s.rooms.Delete(room.ID)
-	for _, conn := range room.GetPlayerConnections() {
-		conn.Session().Delete("room")
+	if err := s.directory.Release(s.ctx, room.ID, s.nodeID); err != nil {
+		s.logger.Error("Failed to release room claim", "room_id", room.ID, "error", err)
+	}
+	s.logs.store(room.ID, room.GetEvents())
+	for _, peer := range room.GetPlayerConnections() {
+		roomsOf(peer).remove(room.ID)
+		if remote, ok := peer.(*remotePeer); ok {
+			s.syncRemoteRooms(remote)
+		}
 	}
 	s.logger.Debug("Deleted game room", "room_id", room.ID)
 }
 
-func (s *Server) handleCreateRoom(socket *gws.Conn, msg IncomingMessage) {
+// Removes a client from every room of this node they are in, deleting the rooms that closed as a result.
+func (s *Server) leaveAllRooms(peer Peer) {
+	clientID := mustLoad[string](peer.Session(), "client_id")
+	rooms := roomsOf(peer)
+	for _, room := range rooms.rooms() {
+		rooms.remove(room.ID)
+		room.LeaveRoom(clientID)
+		if room.IsClosed() {
+			s.DeleteGameRoom(room)
+		}
+	}
+}
+
+func (s *Server) handleCreateRoom(peer Peer, msg IncomingMessage) {
 	var payload CreateRoom
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		s.writeError(socket, apperrors.ErrInvalidMessageFormat, msg.RequestID)
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, msg.RequestID)
 		return
 	}
 
 	// Validate payload
 	if ok, errors := s.validator.Validate(&payload); !ok {
-		s.writeError(socket, apperrors.ErrValidationFailed, msg.RequestID, errors)
+		s.writeError(peer, apperrors.ErrValidationFailed, msg.RequestID, errors)
 		return
 	}
 
 	if !slices.Contains(validGameModes, payload.GameMode) {
-		s.writeError(socket, apperrors.ErrInvalidGameMode, msg.RequestID)
+		s.writeError(peer, apperrors.ErrInvalidGameMode, msg.RequestID)
 		return
 	}
 
-	clientID, _, hasRoom := s.getClientContext(socket)
-	if hasRoom {
-		s.writeError(socket, apperrors.ErrAlreadyInGame, msg.RequestID)
+	clientID := mustLoad[string](peer.Session(), "client_id")
+	if !s.canEnterRoom(peer) {
+		s.writeError(peer, apperrors.ErrAlreadyInGame, msg.RequestID)
 		return
 	}
 
+	// Rooms without their own grace period follow the setting, which can change while they are open
+	var abandonGrace time.Duration
+	if payload.AbandonGracePeriod > 0 {
+		abandonGrace = time.Duration(payload.AbandonGracePeriod) * time.Second
+	}
+
 	roomID, err := s.generateRoomID()
 	if err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
 	room, err := NewGameRoom(
 		RoomConfig{
-			ID:           roomID,
-			GameMode:     payload.GameMode,
-			GameType:     payload.GameType,
-			AIDifficulty: payload.Difficulty,
-			Logger:       s.logger,
+			ID:             roomID,
+			GameMode:       payload.GameMode,
+			GameType:       payload.GameType,
+			AIDifficulty:   payload.Difficulty,
+			SpectatorDelay: payload.SpectatorDelay,
+			SeriesLength:   payload.SeriesLength,
+			ColorPolicy:    payload.ColorPolicy,
+			AbandonGrace:   abandonGrace,
+			Settings:       s.cfg,
+			Logger:         s.logger,
 		},
 		InitialPlayer{
 			ClientID: clientID,
 			Username: payload.Username,
-			Conn:     socket,
+			Peer:     peer,
 		},
 	)
 	if err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		// The claimed ID is not used, so other nodes can have it
+		if releaseErr := s.directory.Release(s.ctx, roomID, s.nodeID); releaseErr != nil {
+			s.logger.Error("Failed to release room claim", "room_id", roomID, "error", releaseErr)
+		}
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
-	socket.Session().Store("room", room)
+	roomsOf(peer).add(room)
 
 	s.rooms.Store(roomID, room)
-	s.logger.Debug("Created new game room", "room_id", roomID, "game_mode", payload.GameMode, "game_type", payload.GameType)
+	s.roomRequestLogger(peer, msg.RequestID, roomID).Debug("Created new game room", "game_mode", payload.GameMode, "game_type", payload.GameType)
 
 	if room.GameMode == "singleplayer" {
 		room.StartGame()
 	}
 
-	socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeRoomCreated, types.JSONMap{
-		"room_id":      roomID,
-		"is_spectator": false,
-	}, msg.RequestID))
+	respond(peer, OutgoingMessage{Type: MsgTypeRoomCreated, RoomID: roomID, RequestID: msg.RequestID, Payload: RoomCreated{
+		RoomID:      roomID,
+		IsSpectator: false,
+	}})
 }
 
-func (s *Server) handleJoinRoom(socket *gws.Conn, msg IncomingMessage) {
-	clientID, _, hasRoom := s.getClientContext(socket)
-	if hasRoom {
-		s.writeError(socket, apperrors.ErrAlreadyInGame, msg.RequestID)
+func (s *Server) handleJoinRoom(peer Peer, msg IncomingMessage) {
+	clientID := mustLoad[string](peer.Session(), "client_id")
+	if !s.canEnterRoom(peer) {
+		s.writeError(peer, apperrors.ErrAlreadyInGame, msg.RequestID)
 		return
 	}
 
 	var payload JoinRoom
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		s.writeError(socket, apperrors.ErrInvalidMessageFormat, msg.RequestID)
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, msg.RequestID)
 		return
 	}
 
 	// Validate payload
 	if ok, errors := s.validator.Validate(&payload); !ok {
-		s.writeError(socket, apperrors.ErrValidationFailed, msg.RequestID, errors)
+		s.writeError(peer, apperrors.ErrValidationFailed, msg.RequestID, errors)
+		return
+	}
+
+	rooms := roomsOf(peer)
+	if rooms.has(payload.RoomID) {
+		s.writeError(peer, apperrors.ErrAlreadyInGame, msg.RequestID)
 		return
 	}
 
 	room := s.GetGameRoom(payload.RoomID)
 	if room == nil {
-		s.writeError(socket, apperrors.ErrRoomNotFound, msg.RequestID)
+		// Rooms of other nodes are joined through the node that owns them.
+		// Clients of other nodes are only forwarded here, so they are never forwarded again.
+		if _, remote := peer.(*remotePeer); !remote {
+			node, err := s.directory.Owner(s.ctx, payload.RoomID)
+			if err == nil && node != s.nodeID {
+				// Recorded right away so the client can talk to the room as soon as it is told it joined.
+				// The owner confirms the rooms the client is in after handling the message.
+				rooms.addRemote(payload.RoomID, node)
+				if s.forward(peer, node, msg) != nil {
+					rooms.remove(payload.RoomID)
+				}
+				return
+			}
+		}
+		s.writeError(peer, apperrors.ErrRoomNotFound, msg.RequestID)
 		return
 	}
 
-	isSpectator, err := room.EnterRoom(clientID, socket, payload.Username)
+	isSpectator, err := room.EnterRoom(clientID, peer, payload.Username)
 	if err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
-	socket.Session().Store("room", room)
+	rooms.add(room)
 
-	s.logger.Debug("Client joined game room", "room_id", room.ID, "client_id", clientID, "is_spectator", isSpectator)
+	s.roomRequestLogger(peer, msg.RequestID, room.ID).Debug("Client joined game room", "is_spectator", isSpectator)
 
-	socket.WriteAsync(gws.OpcodeText, NewMessage(MsgTypeJoinedRoom, types.JSONMap{
-		"is_spectator": isSpectator,
-		"game_mode":    room.GameMode,
-		"game_type":    room.GameType,
-		"game_state":   room.GetGameState(),
-		"messages":     room.GetMessages(isSpectator),
-	}, msg.RequestID), func(err error) {
+	// The seq of the state is where the client starts following the room events
+	state, seq := room.GetGameStateFor(clientID)
+	respondAsync(peer, OutgoingMessage{Type: MsgTypeJoinedRoom, RoomID: room.ID, RequestID: msg.RequestID, Seq: seq, Payload: RoomJoined{
+		IsSpectator: isSpectator,
+		GameMode:    room.GameMode,
+		GameType:    room.GameType,
+		GameState:   state,
+		Messages:    room.GetMessages(isSpectator),
+	}}, func(err error) {
 		if err != nil {
-			s.logger.Error("Failed to send join room confirmation", "error", err)
+			s.roomRequestLogger(peer, msg.RequestID, room.ID).Error("Failed to send join room confirmation", "error", err)
 		}
 	})
 
@@ -316,36 +565,36 @@ func (s *Server) handleJoinRoom(socket *gws.Conn, msg IncomingMessage) {
 	}
 }
 
-func (s *Server) handleLeaveRoom(socket *gws.Conn, msg IncomingMessage) {
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleLeaveRoom(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
-	socket.Session().Delete("room")
+	roomsOf(peer).remove(room.ID)
 	room.LeaveRoom(clientID)
 	if room.IsClosed() {
 		s.DeleteGameRoom(room)
 	}
 
-	s.logger.Debug("Client left game room", "room_id", room.ID, "client_id", clientID)
+	s.roomRequestLogger(peer, msg.RequestID, room.ID).Debug("Client left game room")
 
-	err := socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeLeftRoom, nil, msg.RequestID))
+	err = respond(peer, OutgoingMessage{Type: MsgTypeLeftRoom, RoomID: room.ID, RequestID: msg.RequestID})
 	if err != nil {
-		s.logger.Error("Failed to send left room confirmation", "error", err)
+		s.roomRequestLogger(peer, msg.RequestID, room.ID).Error("Failed to send left room confirmation", "error", err)
 	}
 }
 
-func (s *Server) handleMove(socket *gws.Conn, msg IncomingMessage) {
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleMove(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
 	if _, err := room.HandleMove(clientID, msg.RequestID, msg.Payload); err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		s.writeError(peer, err, msg.RequestID)
 	}
 
 	if room.IsClosed() {
@@ -353,21 +602,21 @@ func (s *Server) handleMove(socket *gws.Conn, msg IncomingMessage) {
 	}
 }
 
-func (s *Server) handleForfeit(socket *gws.Conn, msg IncomingMessage) {
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleForfeit(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
 	if err := room.HandleForfeit(clientID); err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
-	socket.WriteAsync(gws.OpcodeText, NewMessage(MsgTypeAck, nil, msg.RequestID), func(err error) {
+	respondAsync(peer, OutgoingMessage{Type: MsgTypeAck, RoomID: room.ID, RequestID: msg.RequestID}, func(err error) {
 		if err != nil {
-			s.logger.Error("Failed to send forfeit acknowledgment", "error", err)
+			s.requestLogger(peer, msg.RequestID).Error("Failed to send forfeit acknowledgment", "error", err)
 		}
 	})
 
@@ -376,160 +625,340 @@ func (s *Server) handleForfeit(socket *gws.Conn, msg IncomingMessage) {
 	}
 }
 
-func (s *Server) handleGameState(socket *gws.Conn, msg IncomingMessage) {
-	_, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleAbort(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
+	}
+
+	if err := room.HandleAbort(clientID); err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
+	}
+
+	respondAsync(peer, OutgoingMessage{Type: MsgTypeAck, RoomID: room.ID, RequestID: msg.RequestID}, func(err error) {
+		if err != nil {
+			s.requestLogger(peer, msg.RequestID).Error("Failed to send abort acknowledgment", "error", err)
+		}
+	})
+}
+
+func (s *Server) handleGameState(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
+	}
+
+	state, seq := room.GetGameStateFor(clientID)
+	respond(peer, OutgoingMessage{Type: MsgTypeGameState, RoomID: room.ID, Payload: state, RequestID: msg.RequestID, Seq: seq})
+}
+
+func (s *Server) handleResync(peer Peer, msg IncomingMessage) {
+	var payload Resync
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, msg.RequestID)
+		return
+	}
+
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
-	state := room.GetGameState()
-	socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeGameState, state, msg.RequestID))
+	result, err := room.Resync(clientID, payload.LastSeq)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
+	}
+
+	s.requestLogger(peer, msg.RequestID).Debug("Client resynced", "last_seq", payload.LastSeq, "missed", len(result.Events), "snapshot", result.GameState != nil)
+	respond(peer, OutgoingMessage{Type: MsgTypeResync, RoomID: room.ID, Payload: result, RequestID: msg.RequestID})
 }
 
-func (s *Server) handleSendMessage(socket *gws.Conn, msg IncomingMessage) {
+func (s *Server) handleSendMessage(peer Peer, msg IncomingMessage) {
 	var payload ChatMessage
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		s.writeError(socket, apperrors.ErrInvalidMessageFormat, msg.RequestID)
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, msg.RequestID)
 		return
 	}
 
 	// Validate payload
 	if ok, errors := s.validator.Validate(&payload); !ok {
-		s.writeError(socket, apperrors.ErrValidationFailed, msg.RequestID, errors)
+		s.writeError(peer, apperrors.ErrValidationFailed, msg.RequestID, errors)
 		return
 	}
 
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
 	if err := room.HandleChatMessage(clientID, msg.RequestID, payload.Content); err != nil {
-		s.writeError(socket, err, msg.RequestID)
+		s.writeError(peer, err, msg.RequestID)
 	}
 }
 
-func (s *Server) handleRequestRematch(socket *gws.Conn, msg IncomingMessage) {
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleRequestRematch(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
-	if err := room.RequestRematch(clientID); err != nil {
-		s.writeError(socket, err, msg.RequestID)
+	if err := room.RequestRematch(clientID, msg.RequestID); err != nil {
+		s.writeError(peer, err, msg.RequestID)
+	}
+}
+
+func (s *Server) handleClaimAbandon(peer Peer, msg IncomingMessage) {
+	var payload ClaimAbandon
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, msg.RequestID)
+		return
+	}
+
+	// Validate payload
+	if ok, errors := s.validator.Validate(&payload); !ok {
+		s.writeError(peer, apperrors.ErrValidationFailed, msg.RequestID, errors)
 		return
 	}
 
-	if err := socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeAck, nil, msg.RequestID)); err != nil {
-		s.logger.Error("Failed to send acknowledgment", "error", err)
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
+	}
+
+	if err := room.ClaimAbandonment(clientID, payload.Result); err != nil {
+		s.writeError(peer, err, msg.RequestID)
+		return
 	}
+
+	respondAsync(peer, OutgoingMessage{Type: MsgTypeAck, RoomID: room.ID, RequestID: msg.RequestID}, func(err error) {
+		if err != nil {
+			s.requestLogger(peer, msg.RequestID).Error("Failed to send claim acknowledgment", "error", err)
+		}
+	})
 }
 
-func (s *Server) handleCancelRematch(socket *gws.Conn, msg IncomingMessage) {
-	clientID, room, hasRoom := s.getClientContext(socket)
-	if !hasRoom {
-		s.writeError(socket, apperrors.ErrNotInGame, msg.RequestID)
+func (s *Server) handleCancelRematch(peer Peer, msg IncomingMessage) {
+	clientID, room, err := s.getClientContext(peer, msg.RoomID)
+	if err != nil {
+		s.writeError(peer, err, msg.RequestID)
 		return
 	}
 
-	if err := room.CancelRematchRequest(clientID); err != nil {
-		s.writeError(socket, err, msg.RequestID)
+	if err := room.CancelRematchRequest(clientID, msg.RequestID); err != nil {
+		s.writeError(peer, err, msg.RequestID)
+	}
+}
+
+// Answers a duplicate request with the responses of the original one.
+// Duplicates of a request that is still being handled are dropped, the client gets the responses once it is done.
+func (s *Server) replayResponses(peer Peer, msg IncomingMessage, responses []cachedResponse, done bool) {
+	log := s.requestLogger(peer, msg.RequestID)
+	if !done {
+		log.Debug("Dropped duplicate request still in progress", "type", msg.Type)
 		return
 	}
 
-	if err := socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeAck, nil, msg.RequestID)); err != nil {
-		s.logger.Error("Failed to send acknowledgment", "error", err)
+	log.Debug("Replayed responses to duplicate request", "type", msg.Type, "responses", len(responses))
+	for _, response := range responses {
+		if err := sendEncoded(peer, response.msgType, response.data); err != nil {
+			log.Error("Failed to replay response", "error", err)
+			return
+		}
 	}
 }
 
-// ------------------ WebSocket event handlers ------------------
-func (s *Server) OnOpen(socket *gws.Conn) {
-	// Get client id from session if provided during upgrade
-	clientID := mustLoad[string](socket.Session(), "client_id")
+// ------------------ Transport entry points ------------------
+
+// Registers a newly connected client. Transports call this once the connection is open.
+// The client keeps the client_id stored in the peer session, or is assigned a new one.
+// The protocol version requested in the session is negotiated first, unsupported clients are sent an error and closed.
+func (s *Server) Connect(peer Peer) error {
+	requested := mustLoad[string](peer.Session(), "protocol")
+	version, err := ParseProtocolVersion(requested)
+	if err != nil {
+		s.connLogger(peer).Info("Rejected client with unsupported protocol version", "protocol", requested)
+		sendMessageSync(peer, OutgoingMessage{Type: MsgTypeError, Payload: apperrors.New(err, ProtocolRange{
+			Min: ProtocolVersionMin,
+			Max: ProtocolVersionCurrent,
+		})})
+		peer.Close(CloseUpgradeRequired, err.Error())
+		return err
+	}
+
+	// Older clients talk to the server through the compatibility shim
+	if version < ProtocolVersionCurrent {
+		shim := &shimPeer{Peer: peer, version: version}
+		peer.Session().Store("shim", shim)
+		peer = shim
+	}
+	peer.Session().Store("protocol_version", version)
+
+	encoding := mustLoad[Encoding](peer.Session(), "encoding")
+	if encoding == "" {
+		encoding = EncodingJSON
+	}
+
+	clientID := mustLoad[string](peer.Session(), "client_id")
 	if clientID == "" {
 		clientID = uuid.New().String()
-		socket.Session().Store("client_id", clientID)
+		peer.Session().Store("client_id", clientID)
 	}
 
-	// Set ping deadline
-	if err := socket.SetDeadline(time.Now().Add(PingInterval + PingWait)); err != nil {
-		s.logger.Error("failed to set deadline", "error", err, "client_id", clientID)
+	connID := mustLoad[string](peer.Session(), "conn_id")
+	if connID == "" {
+		connID = uuid.NewString()
+		peer.Session().Store("conn_id", connID)
 	}
-	socket.WriteMessage(gws.OpcodeText, NewMessage(MsgTypeConnected, types.JSONMap{
-		"client_id": clientID,
-	}, ""))
-	s.logger.Info("New client connected", "client_id", clientID)
+
+	logger := s.logger.With("conn_id", connID, "client_id", clientID)
+	peer.Session().Store("logger", logger)
+
+	peer.Session().Store("requests", s.requestCacheFor(clientID))
+	peer.Session().Store("rooms", newClientRooms())
+	sendMessageSync(peer, OutgoingMessage{Type: MsgTypeConnected, Payload: Connected{
+		ClientID: clientID,
+		Protocol: protocolInfo(version, encoding),
+	}})
+	metrics.Connections.Inc()
+	s.connections.Add(1)
+	s.clients.Store(clientID, peer)
+	logger.Info("New client connected", "protocol", version, "encoding", encoding)
+	return nil
 }
 
-func (s *Server) OnClose(socket *gws.Conn, err error) {
-	clientID := mustLoad[string](socket.Session(), "client_id")
-	room := mustLoad[*GameRoom](socket.Session(), "room")
+// Removes a client that disconnected from the server and the rooms they were in.
+// The error is the reason the connection was lost, if it was not closed cleanly.
+func (s *Server) Disconnect(peer Peer, err error) {
+	// Rejected clients were never registered
+	if mustLoad[int](peer.Session(), "protocol_version") == 0 {
+		return
+	}
+	peer = sessionPeer(peer)
 
-	if room != nil {
-		room.LeaveRoom(clientID)
-		if room.IsClosed() {
-			s.DeleteGameRoom(room)
-		}
+	metrics.Connections.Dec()
+	s.connections.Add(-1)
+	clientID := mustLoad[string](peer.Session(), "client_id")
+
+	// Requests are remembered for a while after the client leaves, in case it retries them after reconnecting
+	if cache := mustLoad[*requestCache](peer.Session(), "requests"); cache != nil {
+		cache.touch()
 	}
 
-	if err != nil {
-		s.logger.Info("Client disconnected due to unexpected error", "client_id", clientID, "error", err)
-	} else {
-		s.logger.Info("Client disconnected", "client_id", clientID)
+	// A client that reconnected with the same ID has already replaced this connection
+	if current, ok := s.clients.Load(clientID); ok && current == peer {
+		s.clients.Delete(clientID)
 	}
-}
 
-func (s *Server) OnPing(socket *gws.Conn, payload []byte) {
-	if err := socket.SetDeadline(time.Now().Add(PingInterval + PingWait)); err != nil {
-		s.logger.Error("failed to set deadline on ping", "error", err)
+	for _, node := range roomsOf(peer).nodes() {
+		forwardErr := s.publish(node, clusterMessage{
+			Kind:     clusterDisconnect,
+			ConnID:   mustLoad[string](peer.Session(), "conn_id"),
+			ClientID: clientID,
+		})
+		if forwardErr != nil {
+			s.connLogger(peer).Error("Failed to forward disconnect", "node", node, "error", forwardErr)
+		}
 	}
-	if err := socket.WriteString("pong"); err != nil {
-		s.logger.Error("failed to write pong", "error", err)
+	s.leaveAllRooms(peer)
+
+	if err != nil {
+		s.connLogger(peer).Info("Client disconnected due to unexpected error", "error", err)
+	} else {
+		s.connLogger(peer).Info("Client disconnected")
 	}
 }
 
-func (s *Server) OnMessage(socket *gws.Conn, message *gws.Message) {
-	// Handle ping messages
-	if b := message.Bytes(); len(b) == 4 && string(b) == "ping" {
-		s.OnPing(socket, nil)
+// Handles an encoded message received from a client.
+func (s *Server) Receive(peer Peer, data []byte) {
+	// Messages from rejected clients that arrive before the connection closes are ignored
+	version := mustLoad[int](peer.Session(), "protocol_version")
+	if version == 0 {
 		return
 	}
+	peer = sessionPeer(peer)
 
 	var msg IncomingMessage
-	if err := json.Unmarshal(message.Bytes(), &msg); err != nil {
-		s.writeError(socket, apperrors.ErrInvalidMessageFormat, "")
+	if err := json.Unmarshal(data, &msg); err != nil {
+		metrics.MessagesReceived.WithLabelValues("invalid").Inc()
+		s.writeError(peer, apperrors.ErrInvalidMessageFormat, "")
 		return
 	}
 
 	// Validate incoming message
 	if ok, errors := s.validator.Validate(&msg); !ok {
-		s.writeError(socket, apperrors.ErrValidationFailed, "", errors)
+		metrics.MessagesReceived.WithLabelValues("invalid").Inc()
+		s.writeError(peer, apperrors.ErrValidationFailed, "", errors)
+		return
+	}
+
+	s.requestLogger(peer, msg.RequestID).Debug("Received message", "type", msg.Type)
+
+	// Unknown types are grouped together to keep the metric labels bounded
+	if slices.Contains(incomingMsgTypes, msg.Type) {
+		metrics.MessagesReceived.WithLabelValues(string(msg.Type)).Inc()
+	} else {
+		metrics.MessagesReceived.WithLabelValues("unknown").Inc()
+	}
+
+	// Types added after the client's protocol version are unknown to it
+	if !msgTypeSupported(msg.Type, version) {
+		s.writeError(peer, apperrors.ErrInvalidMsgType, msg.RequestID)
 		return
 	}
 
+	// Messages about a room of another node are served by that node, which also answers retried requests
+	if msg.Type != MsgTypeCreateRoom && msg.Type != MsgTypeJoinRoom {
+		if roomID, _, node, err := roomsOf(peer).resolve(msg.RoomID); err == nil && node != "" {
+			msg.RoomID = roomID
+			s.forward(peer, node, msg)
+			return
+		}
+	}
+
+	// A retried request is answered with the original responses instead of being handled again
+	if cache := mustLoad[*requestCache](peer.Session(), "requests"); cache != nil {
+		responses, done, duplicate := cache.begin(msg.RequestID)
+		if duplicate {
+			s.replayResponses(peer, msg, responses, done)
+			return
+		}
+		defer cache.finish(msg.RequestID)
+	}
+
 	switch msg.Type {
 	case MsgTypeCreateRoom:
-		s.handleCreateRoom(socket, msg)
+		s.handleCreateRoom(peer, msg)
 	case MsgTypeJoinRoom:
-		s.handleJoinRoom(socket, msg)
+		s.handleJoinRoom(peer, msg)
 	case MsgTypeLeaveRoom:
-		s.handleLeaveRoom(socket, msg)
+		s.handleLeaveRoom(peer, msg)
 	case MsgTypeMove:
-		s.handleMove(socket, msg)
+		s.handleMove(peer, msg)
 	case MsgTypeForfeit:
-		s.handleForfeit(socket, msg)
+		s.handleForfeit(peer, msg)
+	case MsgTypeAbort:
+		s.handleAbort(peer, msg)
 	case MsgTypeGameState:
-		s.handleGameState(socket, msg)
+		s.handleGameState(peer, msg)
 	case MsgTypeSendMessage:
-		s.handleSendMessage(socket, msg)
+		s.handleSendMessage(peer, msg)
 	case MsgTypeRematch:
-		s.handleRequestRematch(socket, msg)
+		s.handleRequestRematch(peer, msg)
 	case MsgTypeCancelRematch:
-		s.handleCancelRematch(socket, msg)
+		s.handleCancelRematch(peer, msg)
+	case MsgTypeClaimAbandon:
+		s.handleClaimAbandon(peer, msg)
+	case MsgTypeResync:
+		s.handleResync(peer, msg)
 	default:
-		s.writeError(socket, apperrors.ErrInvalidMsgType, msg.RequestID)
+		s.writeError(peer, apperrors.ErrInvalidMsgType, msg.RequestID)
 	}
 }
@@ -0,0 +1,316 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/config"
+	"github.com/CDavidSV/online-flip-flop/games"
+	"github.com/google/uuid"
+	"github.com/lxzan/gws"
+)
+
+const (
+	testTimeout = 5 * time.Second    // Time a test waits for a message before failing
+	testOrigin  = "http://localhost" // Origin websocket test clients connect from
+)
+
+// Returns a started server with the default config, changed by configure if it is set.
+func newTestServer(t *testing.T, configure func(cfg *config.Config)) *Server {
+	t.Helper()
+
+	srv := newUnstartedTestServer(configure)
+	startTestServer(t, srv)
+	return srv
+}
+
+// Returns a server with the default config, changed by configure if it is set, without starting it.
+func newUnstartedTestServer(configure func(cfg *config.Config)) *Server {
+	cfg := config.Default()
+	cfg.AIMoveDelay = 0
+	cfg.AllowedOrigins = []string{testOrigin}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	return NewGameServer(config.NewStore(cfg, nil, logger), logger)
+}
+
+// Starts a server and stops it once the test ends.
+func startTestServer(t *testing.T, srv *Server) {
+	t.Helper()
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+}
+
+// A decoded message sent to a test client.
+type testMessage struct {
+	Type      MsgType         `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	RequestID string          `json:"request_id"`
+	RoomID    string          `json:"room_id"`
+	Seq       uint64          `json:"seq"`
+}
+
+// A client connected to a server through a MemoryPeer.
+type testClient struct {
+	t    *testing.T
+	srv  *Server
+	peer *MemoryPeer
+	id   string
+}
+
+// Connects a new client to the server and waits for the connected message.
+func connectTestClient(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+
+	c := &testClient{t: t, srv: srv, peer: NewMemoryPeer("", 256)}
+	if err := srv.Connect(c.peer); err != nil {
+		t.Fatalf("connecting client: %v", err)
+	}
+
+	var connected Connected
+	c.expectPayload(MsgTypeConnected, &connected)
+	c.id = connected.ClientID
+	return c
+}
+
+// Sends a message about the given room, which may be empty, and returns its request ID.
+func (c *testClient) send(msgType MsgType, roomID string, payload any) string {
+	c.t.Helper()
+
+	msg := IncomingMessage{Type: msgType, RequestID: uuid.NewString(), RoomID: roomID}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			c.t.Fatalf("encoding %s payload: %v", msgType, err)
+		}
+		msg.Payload = data
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("encoding %s message: %v", msgType, err)
+	}
+	c.srv.Receive(c.peer, data)
+	return msg.RequestID
+}
+
+// Waits for the next message of any type.
+func (c *testClient) next() testMessage {
+	c.t.Helper()
+
+	select {
+	case data, ok := <-c.peer.Messages():
+		if !ok {
+			c.t.Fatalf("peer closed while waiting for a message")
+		}
+		var msg testMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.t.Fatalf("decoding message %s: %v", data, err)
+		}
+		return msg
+	case <-time.After(testTimeout):
+		c.t.Fatalf("timed out waiting for a message")
+	}
+	return testMessage{}
+}
+
+// Waits for the next message of the given type, skipping the others.
+func (c *testClient) expect(msgType MsgType) testMessage {
+	c.t.Helper()
+
+	for {
+		if msg := c.next(); msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// Waits for the next message of the given type and decodes its payload into v.
+func (c *testClient) expectPayload(msgType MsgType, v any) testMessage {
+	c.t.Helper()
+
+	msg := c.expect(msgType)
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		c.t.Fatalf("decoding %s payload: %v", msgType, err)
+	}
+	return msg
+}
+
+// Waits for an error message and checks its code.
+func (c *testClient) expectError(code string) {
+	c.t.Helper()
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	c.expectPayload(MsgTypeError, &payload)
+	if payload.Code != code {
+		c.t.Fatalf("got error %q, want %q", payload.Code, code)
+	}
+}
+
+// Checks that no message of the given type arrives within wait.
+func (c *testClient) expectNone(msgType MsgType, wait time.Duration) {
+	c.t.Helper()
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case data, ok := <-c.peer.Messages():
+			if !ok {
+				return
+			}
+			var msg testMessage
+			json.Unmarshal(data, &msg)
+			if msg.Type == msgType {
+				c.t.Fatalf("got unexpected %s: %s", msgType, data)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Creates a room and returns its ID.
+func (c *testClient) createRoom(payload CreateRoom) string {
+	c.t.Helper()
+
+	c.send(MsgTypeCreateRoom, "", payload)
+	var created RoomCreated
+	c.expectPayload(MsgTypeRoomCreated, &created)
+	return created.RoomID
+}
+
+// Returns the color of a player in a game state.
+func colorOf(t *testing.T, state GameState, clientID string) games.PlayerSide {
+	t.Helper()
+
+	for _, player := range state.Players {
+		if player.ID == clientID {
+			return player.Color
+		}
+	}
+	t.Fatalf("player %s is not in the game", clientID)
+	return -1
+}
+
+// Creates a multiplayer room between two clients and waits for the game to start.
+// Returns the room ID and the state the game started with.
+func startMultiplayerGame(t *testing.T, host, guest *testClient) (string, GameState) {
+	t.Helper()
+
+	roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host"})
+	guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+	guest.expect(MsgTypeJoinedRoom)
+
+	var state GameState
+	host.expectPayload(MsgTypeGameStart, &state)
+	return roomID, state
+}
+
+// A client connected to a server over a real websocket connection.
+type testSocket struct {
+	gws.BuiltinEventHandler
+	t        *testing.T
+	conn     *gws.Conn
+	messages chan testFrame
+	closed   chan gws.CloseError
+}
+
+// A message received by a testSocket, with the opcode of the frame it came in.
+type testFrame struct {
+	opcode gws.Opcode
+	data   []byte
+}
+
+// Connects to the websocket handler of a server with the given query string.
+func dialTestServer(t *testing.T, srv *Server, query string) *testSocket {
+	t.Helper()
+
+	httpServer := httptest.NewServer(WSHandler(srv))
+	t.Cleanup(httpServer.Close)
+
+	s := &testSocket{t: t, messages: make(chan testFrame, 2*OutboundQueueSize), closed: make(chan gws.CloseError, 1)}
+	conn, _, err := gws.NewClient(s, &gws.ClientOption{
+		Addr:          "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/?" + query,
+		RequestHeader: map[string][]string{"Origin": {testOrigin}},
+	})
+	if err != nil {
+		t.Fatalf("dialing server: %v", err)
+	}
+	s.conn = conn
+	t.Cleanup(func() { conn.NetConn().Close() })
+	go conn.ReadLoop()
+	return s
+}
+
+func (s *testSocket) OnMessage(socket *gws.Conn, message *gws.Message) {
+	s.messages <- testFrame{opcode: message.Opcode, data: append([]byte(nil), message.Bytes()...)}
+	message.Close()
+}
+
+func (s *testSocket) OnClose(socket *gws.Conn, err error) {
+	var closeErr *gws.CloseError
+	if errors.As(err, &closeErr) {
+		s.closed <- *closeErr
+	}
+	close(s.messages)
+}
+
+// Waits for the next message and returns it as sent by the server.
+func (s *testSocket) next() []byte {
+	s.t.Helper()
+
+	return s.nextFrame().data
+}
+
+// Waits for the next message and returns it with the opcode of its frame.
+func (s *testSocket) nextFrame() testFrame {
+	s.t.Helper()
+
+	select {
+	case frame, ok := <-s.messages:
+		if !ok {
+			s.t.Fatalf("connection closed while waiting for a message")
+		}
+		return frame
+	case <-time.After(testTimeout):
+		s.t.Fatalf("timed out waiting for a message")
+	}
+	return testFrame{}
+}
+
+// Waits for the next message and decodes it as JSON.
+func (s *testSocket) nextJSON() testMessage {
+	s.t.Helper()
+
+	var msg testMessage
+	if data := s.next(); json.Unmarshal(data, &msg) != nil {
+		s.t.Fatalf("decoding message %s", data)
+	}
+	return msg
+}
+
+// Waits for the server to close the connection and returns the close frame it sent.
+func (s *testSocket) expectClose() gws.CloseError {
+	s.t.Helper()
+
+	select {
+	case closeErr := <-s.closed:
+		return closeErr
+	case <-time.After(testTimeout):
+		s.t.Fatalf("timed out waiting for the connection to close")
+	}
+	return gws.CloseError{}
+}
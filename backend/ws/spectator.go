@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+)
+
+// Configures how far behind the live game spectators are kept.
+// Only one of Moves or Seconds can be set, a zero value means no delay.
+type SpectatorDelay struct {
+	Moves   int `json:"moves,omitempty" validate:"min=0,max=20,excluded_with=Seconds"`
+	Seconds int `json:"seconds,omitempty" validate:"min=0,max=600"`
+}
+
+// The part of the game state that is held back from spectators while a delay is active.
+type spectatorView struct {
+	board       string
+	currentTurn games.PlayerSide
+	moveHistory []games.MoveHistoryEntry
+}
+
+// A move broadcast waiting to be released to spectators.
+type delayedMove struct {
+	payload   any
+	view      spectatorView
+	releaseAt time.Time
+}
+
+// Returns true if spectators should not receive moves as soon as they are made.
+func (d SpectatorDelay) enabled() bool {
+	return d.Moves > 0 || d.Seconds > 0
+}
+
+// Takes a snapshot of the current board for the spectator view.
+// Must be called from the room goroutine.
+func (gr *GameRoom) currentSpectatorView() spectatorView {
+	return spectatorView{
+		board:       gr.Game.GetBoardString(),
+		currentTurn: gr.Game.CurrentTurn(),
+		moveHistory: gr.Game.GetMoveHistory(),
+	}
+}
+
+// Drops any pending moves and syncs the spectator view with the live game.
+// Called when a new game starts. Must be called from the room goroutine.
+func (gr *GameRoom) resetSpectatorView() {
+	gr.delayedMoves = nil
+	gr.spectatorView = gr.currentSpectatorView()
+}
+
+// Buffers a move broadcast for spectators and releases any moves that are already past the delay.
+// Must be called from the room goroutine.
+func (gr *GameRoom) queueSpectatorMove(payload any) {
+	move := delayedMove{
+		payload: payload,
+		view:    gr.currentSpectatorView(),
+	}
+
+	if gr.spectatorDelay.Seconds > 0 {
+		delay := time.Duration(gr.spectatorDelay.Seconds) * time.Second
+		move.releaseAt = time.Now().Add(delay)
+		time.AfterFunc(delay, func() { gr.do(gr.releaseDueSpectatorMoves) })
+	}
+
+	gr.delayedMoves = append(gr.delayedMoves, move)
+
+	// Move based delay, keep only the last N moves hidden
+	if gr.spectatorDelay.Moves > 0 {
+		for len(gr.delayedMoves) > gr.spectatorDelay.Moves {
+			gr.releaseSpectatorMove()
+		}
+	}
+}
+
+// Sends the oldest buffered move to spectators and advances the spectator view.
+// Must be called from the room goroutine.
+func (gr *GameRoom) releaseSpectatorMove() {
+	move := gr.delayedMoves[0]
+	gr.delayedMoves = gr.delayedMoves[1:]
+
+	gr.spectatorView = move.view
+	gr.broadcastToSpectators(MsgTypeMove, move.payload, nil)
+}
+
+// Releases all buffered moves to spectators, used when the game ends.
+// Must be called from the room goroutine.
+func (gr *GameRoom) flushSpectatorMoves() {
+	for len(gr.delayedMoves) > 0 {
+		gr.releaseSpectatorMove()
+	}
+}
+
+// Called by the delay timers to release the moves whose delay has passed.
+// Must be called from the room goroutine.
+func (gr *GameRoom) releaseDueSpectatorMoves() {
+	if gr.status == StatusClosed {
+		return
+	}
+
+	now := time.Now()
+	for len(gr.delayedMoves) > 0 && !gr.delayedMoves[0].releaseAt.After(now) {
+		gr.releaseSpectatorMove()
+	}
+}
+
+// Constructs the game state as seen by spectators, which may be behind the live game.
+// Must be called from the room goroutine.
+// Player IDs are left out, as spectators are outside the room.
+func (gr *GameRoom) spectatorGameState() GameState {
+	state := gr.gameState()
+	for i := range state.Players {
+		state.Players[i].ID = ""
+	}
+	if !gr.spectatorDelay.enabled() {
+		return state
+	}
+
+	state.Board = gr.spectatorView.board
+	state.CurrentTurn = gr.spectatorView.currentTurn
+	state.MoveHistory = gr.spectatorView.moveHistory
+	return state
+}
+
+// Returns the state spectators see, with the players as shown outside the room.
+// Must be called from the room goroutine.
+func (gr *GameRoom) publicGameState() PublicGameState {
+	return PublicGameState{GameState: gr.spectatorGameState(), Players: gr.publicPlayers()}
+}
+
+// Returns the payload of a spectator event as shown outside the room, without client IDs.
+// Must be called from the room goroutine.
+func (gr *GameRoom) publicPayload(payload any) any {
+	switch p := payload.(type) {
+	case GameState:
+		return PublicGameState{GameState: p, Players: gr.publicPlayers()}
+	case MoveMade:
+		p.PlayerID = ""
+		return p
+	case SavedMessage:
+		p.ClientID = ""
+		return p
+	case PlayerUpdate:
+		return PlayerUpdate{Color: gr.colorOf(p.PlayerID)}
+	case PlayerLeft:
+		p.PlayerID, p.Color = "", gr.colorOf(p.PlayerID)
+		return p
+	case PlayerRejoined:
+		p.PlayerID, p.Color = "", gr.colorOf(p.PlayerID)
+		return p
+	}
+	return payload
+}
+
+// Returns the color of a player, or nil if the client is not a player.
+// Must be called from the room goroutine.
+func (gr *GameRoom) colorOf(clientID string) *games.PlayerSide {
+	if player := gr.getPlayer(clientID); player != nil {
+		color := player.Color
+		return &color
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CDavidSV/online-flip-flop/games"
+)
+
+func TestSpectatorsStayMovesBehind(t *testing.T) {
+	srv := newTestServer(t, nil)
+	host := connectTestClient(t, srv)
+	guest := connectTestClient(t, srv)
+	roomID := host.createRoom(CreateRoom{GameType: games.TYPE_FLIPFLOP3x3, GameMode: "multiplayer", Username: "host", SpectatorDelay: SpectatorDelay{Moves: 1}})
+	guest.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "guest"})
+	var state GameState
+	host.expectPayload(MsgTypeGameStart, &state)
+
+	spectator := connectTestClient(t, srv)
+	spectator.send(MsgTypeJoinRoom, "", JoinRoom{RoomID: roomID, Username: "spectator"})
+	spectator.expect(MsgTypeJoinedRoom)
+
+	// Two moves are made, spectators only get the first one while the second is held back
+	mirror := games.NewFlipFlopGame(games.FlipFlop3x3)
+	players := map[games.PlayerSide]*testClient{
+		colorOf(t, state, host.id):  host,
+		colorOf(t, state, guest.id): guest,
+	}
+	boards := make([]string, 0, 2)
+	for range 2 {
+		mover := players[mirror.CurrentTurn()]
+		move := firstValidMove(t, mirror)
+		applyMove(t, mirror, move)
+		mover.send(MsgTypeMove, roomID, move)
+		mover.expect(MsgTypeAck)
+		boards = append(boards, mirror.GetBoardString())
+	}
+
+	var made MoveMade
+	spectator.expectPayload(MsgTypeMove, &made)
+	if made.Board != boards[0] {
+		t.Fatalf("spectator got board %s, want the board after the first move %s", made.Board, boards[0])
+	}
+
+	// The game state spectators ask for is as far behind as their moves
+	var spectated, live GameState
+	spectator.send(MsgTypeGameState, roomID, nil)
+	spectator.expectPayload(MsgTypeGameState, &spectated)
+	host.send(MsgTypeGameState, roomID, nil)
+	host.expectPayload(MsgTypeGameState, &live)
+	if spectated.Board != boards[0] || live.Board != boards[1] {
+		t.Fatalf("got board %s for the spectator and %s for the host, want %s and %s", spectated.Board, live.Board, boards[0], boards[1])
+	}
+
+	// The held back move is released before the end of the game
+	host.send(MsgTypeForfeit, roomID, nil)
+	msg := spectator.next()
+	for msg.Type != MsgTypeMove {
+		if msg.Type == MsgTypeGameEnd {
+			t.Fatal("game ended before the held back move was released")
+		}
+		msg = spectator.next()
+	}
+	if json.Unmarshal(msg.Payload, &made); made.Board != boards[1] {
+		t.Fatalf("spectator got board %s, want the board after the second move %s", made.Board, boards[1])
+	}
+	spectator.expect(MsgTypeGameEnd)
+}
@@ -0,0 +1,177 @@
+package ws
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/lxzan/gws"
+)
+
+// A peer connected over a websocket. Messages are sent as text frames, or as binary frames for MessagePack clients.
+// Messages are queued and written by the connection's own writer, so senders never wait on a slow client.
+type wsPeer struct {
+	conn     *gws.Conn
+	encoding Encoding
+	queue    *outboundQueue
+}
+
+func (p *wsPeer) Session() gws.SessionStorage {
+	return p.conn.Session()
+}
+
+func (p *wsPeer) Send(msg []byte) error {
+	done := make(chan error, 1)
+	p.SendAsync(msg, func(err error) {
+		done <- err
+	})
+	return <-done
+}
+
+// Messages sent directly answer a single client, so the slow consumer policy never drops them.
+func (p *wsPeer) SendAsync(msg []byte, callback func(error)) {
+	p.sendTyped("", msg, callback)
+}
+
+func (p *wsPeer) sendTyped(msgType MsgType, msg []byte, callback func(error)) {
+	opcode, data := gws.OpcodeText, msg
+	if p.encoding == EncodingMsgpack {
+		var err error
+		if data, err = jsonToMsgpack(msg); err != nil {
+			if callback != nil {
+				callback(err)
+			}
+			return
+		}
+		opcode = gws.OpcodeBinary
+	}
+
+	err := p.queue.push(outboundMessage{msgType: msgType, opcode: opcode, data: data, callback: callback})
+	if err != nil && callback != nil {
+		callback(err)
+	}
+}
+
+// Closes the connection once the messages queued before it are written.
+func (p *wsPeer) Close(code uint16, reason string) error {
+	return p.queue.close(code, reason)
+}
+
+// Returns the peer wrapping a websocket connection.
+func peerOf(socket *gws.Conn) *wsPeer {
+	return mustLoad[*wsPeer](socket.Session(), "peer")
+}
+
+// Websocket handler.
+func WSHandler(server *Server) http.HandlerFunc {
+	upgrader := gws.NewUpgrader(server, &gws.ServerOption{
+		ParallelEnabled:   true,
+		Recovery:          gws.Recovery,
+		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
+	})
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		// Verify that the connecting client is from an allowed origin
+		origin := req.Header.Get("Origin")
+		if !server.cfg.Load().AllowsOrigin(origin) {
+			server.logger.Warn("WebSocket connection rejected due to invalid origin", "origin", origin, "request_id", middleware.GetReqID(req.Context()))
+			http.Error(res, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// New clients should be routed to another replica while draining
+		if server.draining.Load() {
+			http.Error(res, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Clients pick the message encoding when connecting
+		encoding, err := ParseEncoding(req.URL.Query().Get("encoding"))
+		if err != nil {
+			http.Error(res, "Unsupported encoding", http.StatusBadRequest)
+			return
+		}
+
+		// Upgrade the connections
+		socket, err := upgrader.Upgrade(res, req)
+		if err != nil {
+			server.logger.Error("WebSocket upgrade failed", "error", err, "request_id", middleware.GetReqID(req.Context()))
+			return
+		}
+		peer := &wsPeer{conn: socket, encoding: encoding, queue: newOutboundQueue()}
+		socket.Session().Store("peer", peer)
+		socket.Session().Store("encoding", encoding)
+
+		// Identifies the connection in the logs, the request ID links it to the upgrade request
+		connID := uuid.NewString()
+		socket.Session().Store("conn_id", connID)
+		server.logger.Debug("WebSocket connection upgraded", "conn_id", connID, "request_id", middleware.GetReqID(req.Context()))
+
+		// Store client_id from query params in the session
+		clientID := req.URL.Query().Get("client_id")
+		if clientID != "" {
+			// Validate that it's a valid UUID
+			if _, err := uuid.Parse(clientID); err == nil {
+				socket.Session().Store("client_id", clientID)
+			}
+		}
+
+		// The protocol version is negotiated once the connection is open, so that unsupported clients can be told why they are rejected
+		socket.Session().Store("protocol", req.URL.Query().Get("protocol"))
+
+		go peer.queue.run(socket)
+		go func() {
+			socket.ReadLoop()
+		}()
+	}
+}
+
+// ------------------ WebSocket event handlers ------------------
+func (s *Server) OnOpen(socket *gws.Conn) {
+	peer := peerOf(socket)
+	if err := s.Connect(peer); err != nil {
+		return
+	}
+
+	// Set ping deadline
+	if err := socket.SetDeadline(time.Now().Add(PingInterval + PingWait)); err != nil {
+		s.connLogger(peer).Error("failed to set deadline", "error", err)
+	}
+}
+
+func (s *Server) OnClose(socket *gws.Conn, err error) {
+	peer := peerOf(socket)
+	peer.queue.stop()
+	s.Disconnect(peer, err)
+}
+
+func (s *Server) OnPing(socket *gws.Conn, payload []byte) {
+	if err := socket.SetDeadline(time.Now().Add(PingInterval + PingWait)); err != nil {
+		s.connLogger(peerOf(socket)).Error("failed to set deadline on ping", "error", err)
+	}
+	// Queued like any other message, so the read goroutine never writes to the connection
+	peer := peerOf(socket)
+	if err := peer.queue.push(outboundMessage{opcode: gws.OpcodeText, data: []byte("pong")}); err != nil {
+		s.connLogger(peer).Error("failed to queue pong", "error", err)
+	}
+}
+
+func (s *Server) OnMessage(socket *gws.Conn, message *gws.Message) {
+	// Handle ping messages
+	if b := message.Bytes(); len(b) == 4 && string(b) == "ping" {
+		s.OnPing(socket, nil)
+		return
+	}
+
+	data := message.Bytes()
+	if message.Opcode == gws.OpcodeBinary {
+		var err error
+		if data, err = msgpackToJSON(data); err != nil {
+			// Left empty so that the server reports it as an invalid message
+			data = nil
+		}
+	}
+
+	s.Receive(peerOf(socket), data)
+}
@@ -61,6 +61,7 @@ export default function GamePage() {
     const { isConnected, on, sendRequest } = useWebSocket();
     const router = useRouter();
     const {
+        roomId,
         inRoom,
         gameType,
         gameMode,
@@ -292,7 +293,7 @@ export default function GamePage() {
     const requestRematch = () => {
         if (rematch === "requested") return;
 
-        sendRequest("rematch", null)
+        sendRequest("rematch", null, roomId)
             .then(() => {
                 setRematch("requested");
             })
@@ -305,7 +306,7 @@ export default function GamePage() {
     const cancelRematchRequest = () => {
         if (rematch !== "requested") return;
 
-        sendRequest("cancel_rematch", null)
+        sendRequest("cancel_rematch", null, roomId)
             .then(() => {
                 setRematch(null);
             })
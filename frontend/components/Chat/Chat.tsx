@@ -16,7 +16,7 @@ import {
 
 export function Chat({ initialMessages }: { initialMessages?: ChatMessage[] }) {
     const { clientId, isConnected, on, sendRequest } = useWebSocket();
-    const { isSpectator } = useGameRoom();
+    const { roomId, isSpectator } = useGameRoom();
 
     const [chatMessages, setChatMessages] = useState<ChatMessage[]>(
         initialMessages || [],
@@ -34,7 +34,7 @@ export function Chat({ initialMessages }: { initialMessages?: ChatMessage[] }) {
 
         setMessageInput("");
 
-        sendRequest("message", { content: messageCotent })
+        sendRequest("message", { content: messageCotent }, roomId)
             .then(() => {
                 setChatMessages((prevMessages) => [
                     ...prevMessages,
@@ -34,7 +34,7 @@ export function FlipFlop({
     onMoveMade,
 }: BoardProps) {
     const goals = type === GameType.FLIPFLOP_3x3 ? [1, 7] : [2, 22];
-    const { gameStatus, currentTurn, setCurrentTurn, isSpectator } =
+    const { roomId, gameStatus, currentTurn, setCurrentTurn, isSpectator } =
         useGameRoom();
     const { sendRequest, on } = useWebSocket();
 
@@ -397,10 +397,14 @@ export function FlipFlop({
         }
 
         // Change turn
-        sendRequest("move", {
-            from: fromPos,
-            to: toPos,
-        }).catch((reason: unknown) => {
+        sendRequest(
+            "move",
+            {
+                from: fromPos,
+                to: toPos,
+            },
+            roomId,
+        ).catch((reason: unknown) => {
             console.error("Move failed:", reason);
 
             // Reset the board to the previous state
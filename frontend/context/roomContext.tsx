@@ -281,6 +281,22 @@ export function GameRoomProvider({ children }: { children: ReactNode }) {
         setOpponentPlayer(null);
     };
 
+    /**
+     * Leaves the room the client is in before it enters another one.
+     * The server lets a connection stay in several rooms, but the app only follows one.
+     * @param nextRoomId - The room about to be entered, if known
+     */
+    const leavePreviousRoom = async (nextRoomId?: string): Promise<void> => {
+        if (!inRoom || !roomId || roomId === nextRoomId) return;
+
+        try {
+            await sendRequest("leave", null, roomId);
+        } catch (error) {
+            console.error("Failed to leave previous room:", error);
+        }
+        resetState();
+    };
+
     /**
      * Creates a new game room.
      * @param username
@@ -308,6 +324,8 @@ export function GameRoomProvider({ children }: { children: ReactNode }) {
             createGameRequest.difficulty = difficulty;
         }
 
+        await leavePreviousRoom();
+
         try {
             const response = await sendRequest("create", createGameRequest);
 
@@ -361,6 +379,8 @@ export function GameRoomProvider({ children }: { children: ReactNode }) {
             joinGameRequest.username = username;
         }
 
+        await leavePreviousRoom(roomId);
+
         try {
             const response = await sendRequest("join", joinGameRequest);
             if (response.type !== "joined") {
@@ -417,7 +437,7 @@ export function GameRoomProvider({ children }: { children: ReactNode }) {
         if (!isConnected || !inRoom) return false;
 
         try {
-            const response = await sendRequest("leave", null);
+            const response = await sendRequest("leave", null, roomId);
             if (response.type === "left") {
                 resetState();
                 return true;
@@ -439,7 +459,7 @@ export function GameRoomProvider({ children }: { children: ReactNode }) {
         }
 
         try {
-            await sendRequest("forfeit", null);
+            await sendRequest("forfeit", null, roomId);
         } catch (error) {
             console.error("Failed to forfeit game:", error);
             throw error;
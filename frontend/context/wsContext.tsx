@@ -7,8 +7,12 @@ type WebsocketContextType = {
     isConnected: boolean;
     clientId: string | null;
     latency: number;
-    sendMessage: (type: string, payload: any) => void;
-    sendRequest: (type: string, payload: any) => Promise<WSMessage>;
+    sendMessage: (type: string, payload: any, roomId?: string | null) => void;
+    sendRequest: (
+        type: string,
+        payload: any,
+        roomId?: string | null,
+    ) => Promise<WSMessage>;
     on: <T>(
         eventType: WSEventType,
         handler: (payload: T) => void,
@@ -29,6 +33,7 @@ const websocketContext = createContext<WebsocketContextType>({
 const PING_INTERVAL = 40000; // Every 40 seconds
 const RECCONNECT_INTERVAL = 5000; // Every 5 seconds
 const CLIENT_ID_KEY = "ws_client_id";
+const PROTOCOL_VERSION = 3; // Protocol version negotiated with the server, see backend/ws/protocol.go
 const wsURL = process.env.NEXT_PUBLIC_WS_URL || "ws://localhost:8000";
 
 export const useWebSocket = () => useContext(websocketContext);
@@ -186,11 +191,13 @@ export const WebsocketProvider = ({
 
         const ws_client_id = localStorage.getItem(CLIENT_ID_KEY);
 
-        let url = `${wsURL}/ws`;
+        const params = new URLSearchParams({
+            protocol: String(PROTOCOL_VERSION),
+        });
         if (ws_client_id) {
-            url += `?client_id=${ws_client_id}`;
+            params.set("client_id", ws_client_id);
         }
-        const ws = new WebSocket(url);
+        const ws = new WebSocket(`${wsURL}/ws?${params}`);
 
         ws.onopen = handleOnOpen;
         ws.onmessage = handleOnMessage;
@@ -204,9 +211,14 @@ export const WebsocketProvider = ({
      * Sends a message to the server.
      * @param type
      * @param payload
+     * @param roomId Room the message is about, if any
      * @returns request_id of the sent message
      */
-    const sendMessage = (type: string, payload: any): string => {
+    const sendMessage = (
+        type: string,
+        payload: any,
+        roomId?: string | null,
+    ): string => {
         if (!socket.current || socket.current.readyState !== WebSocket.OPEN) {
             return "";
         }
@@ -217,6 +229,9 @@ export const WebsocketProvider = ({
             payload,
             request_id: requestId,
         };
+        if (roomId) {
+            message.room_id = roomId;
+        }
 
         socket.current.send(JSON.stringify(message));
         return requestId;
@@ -226,11 +241,16 @@ export const WebsocketProvider = ({
      * Sends a request to the server and returns a promise that resolves with the response.
      * @param type
      * @param payload
+     * @param roomId Room the request is about, if any
      * @returns Promise that resolves with the server response
      */
-    const sendRequest = (type: string, payload: any): Promise<WSMessage> => {
+    const sendRequest = (
+        type: string,
+        payload: any,
+        roomId?: string | null,
+    ): Promise<WSMessage> => {
         return new Promise<WSMessage>((resolve, reject) => {
-            const requestId = sendMessage(type, payload);
+            const requestId = sendMessage(type, payload, roomId);
 
             requests.current.set(requestId, { resolve, reject });
         });
@@ -0,0 +1,50 @@
+// Checks that the protocol values used in types/types.ts are defined in the protocol schema,
+// so a message type or enum value removed from the backend fails CI instead of the game.
+import { readFileSync } from "fs";
+import { dirname, join } from "path";
+import { fileURLToPath } from "url";
+
+const root = join(dirname(fileURLToPath(import.meta.url)), "..");
+const schema = JSON.parse(readFileSync(join(root, "..", "protocol", "schema.json"), "utf8"));
+const source = readFileSync(join(root, "types", "types.ts"), "utf8");
+const defs = schema.$defs;
+
+// Values of a string enum or union declared in types.ts
+function tsValues(name) {
+    const declaration =
+        source.match(new RegExp(`enum ${name} \\{([^}]*)\\}`)) ??
+        source.match(new RegExp(`type ${name} =([^;]*);`));
+    if (!declaration) {
+        throw new Error(`${name} is not declared in types/types.ts`);
+    }
+    return [...declaration[1].matchAll(/"([^"]*)"/g)].map((match) => match[1]);
+}
+
+// Types of the messages sent by the server
+const outgoingTypes = defs.OutgoingMessage.oneOf.map(
+    (ref) => defs[ref.$ref.split("/").pop()].properties.type.const,
+);
+
+// Declarations of types.ts and the values the schema allows for them
+const checks = {
+    GameType: defs.GameType.enum,
+    GameMode: defs.GameMode.enum,
+    AIDDifficulty: defs.AIDifficulty.enum,
+    GameStatus: defs.Status.enum,
+    WSEventType: outgoingTypes,
+};
+
+let failed = false;
+for (const [name, allowed] of Object.entries(checks)) {
+    for (const value of tsValues(name)) {
+        if (!allowed.includes(value)) {
+            console.error(`${name} has "${value}", which is not in protocol/schema.json`);
+            failed = true;
+        }
+    }
+}
+
+if (failed) {
+    process.exit(1);
+}
+console.log("types/types.ts matches protocol/schema.json");
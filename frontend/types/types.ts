@@ -1,7 +1,6 @@
 enum GameType {
     FLIPFLOP_3x3 = "flipflop3x3",
     FLIPFLOP_5x5 = "flipflop5x5",
-    FLIPFOUR = "flipfour",
 }
 
 enum GameMode {
@@ -73,6 +72,8 @@ interface WSMessage {
     type: string;
     payload: any;
     request_id?: string;
+    room_id?: string;
+    seq?: number;
 }
 
 interface WSError {